@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pxi/x/envrc"
+)
+
+// The allow list records the envrc files that may be evaluated. Every line
+// holds the hex-encoded SHA-256 hash of a file followed by its path, in the
+// format of sha256sum. Since the hash covers the content, editing a file
+// requires allowing it again. For a file with include directives, the hash
+// covers the content with the included files inlined, so it no longer matches
+// the output of sha256sum.
+
+// allowPath returns the path of the allow list.
+func allowPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "chenv", "allowed"), nil
+}
+
+// loadAllowed returns the allow list as a set of "hash  path" lines.
+func loadAllowed() (map[string]bool, error) {
+	path, err := allowPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := map[string]bool{}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		if line := strings.TrimSpace(scan.Text()); line != "" {
+			list[line] = true
+		}
+	}
+	return list, scan.Err()
+}
+
+// allowEntry returns the allow list line for f, read by envrc.ReadFile. The
+// hash covers the source f was parsed from, with its includes resolved, so
+// editing an included file changes the hash too.
+func allowEntry(f *envrc.File) string {
+	sum := sha256.Sum256(f.Source)
+	return hex.EncodeToString(sum[:]) + "  " + f.Path
+}
+
+// allow adds the envrc file in dir to the allow list. If dir is reached
+// through a symbolic link, the file is recorded under the resolved path too,
+// so it stays allowed when the paths are resolved with -P.
+func allow(dir string) error {
+	dir, err := envrc.Abs(dir)
+	if err != nil {
+		return err
+	}
+	dirs := []string{dir}
+	if p, err := filepath.EvalSymlinks(dir); err == nil && p != dir {
+		dirs = append(dirs, p)
+	}
+
+	list, err := loadAllowed()
+	if err != nil {
+		return err
+	}
+	var (
+		entries []string
+		first   *envrc.File
+	)
+	for _, dir := range dirs {
+		f, err := envrc.Lookup(dir)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return fmt.Errorf("no envrc file in %s", dir)
+		}
+		if entry := allowEntry(f); !list[entry] {
+			entries = append(entries, entry)
+			if first == nil {
+				first = f
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	lint(first)
+
+	path, err := allowPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintln(f, entry); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// lint writes the warnings of envrc.Lint about f to stderr. The file is
+// allowed anyway; the warnings are a last chance to review it.
+func lint(f *envrc.File) {
+	for _, w := range envrc.Lint(f) {
+		fmt.Fprintf(os.Stderr, "chenv: %s:%d: %s: %s\n", w.Path, w.Line, w.Msg, w.Text)
+	}
+}
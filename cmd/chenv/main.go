@@ -1,8 +1,10 @@
 // Chenv is a simple environment switcher. It is intended to work as an
 // extension to built-in shell commands `cd`, `pushd`, and `popd`.
 //
-// For basic usage, add something like the following to the shell startup
-// scripts:
+// For basic usage, add `eval "$(chenv init bash)"` to the shell startup
+// scripts, replacing bash with zsh as needed. For fish, add
+// `chenv init fish | source` instead. The printed snippet is something like
+// the following:
 //   _chenv() {
 //     builtin "$@" || return $?
 //     eval "$(chenv "$OLDPWD" "$PWD")"
@@ -10,13 +12,56 @@
 //   cd() { _chenv cd "$@"; }
 //   popd() { _chenv popd "$@"; }
 //   pushd() { _chenv pushd "$@"; }
+//   chenv_reload() { eval "$(chenv reload)"; }
+//
+// For other consumers than an interactive shell, the -format flag selects
+// plain KEY=VALUE lines of the simple assignments in the enter sections, or a
+// JSON object with the combined enter and exit sections and the trailing
+// comments of their lines, with the file and line each comes from. Neither
+// includes the pushd and popd framing of the shell script.
+//
+// To evaluate the envrc file of the current directory again, for example
+// after editing it, `chenv reload` prints its exit section followed by its
+// enter section, and `chenv export` only its enter section. The snippet of
+// `chenv init` defines a chenv_reload function running the former.
+//
+// Only envrc files recorded with `chenv allow` are evaluated. Allowing a file
+// records the hash of its content, so a changed file has to be allowed again.
+// Files that are not allowed are skipped with a warning. While allowing a
+// file, chenv prints warnings about dangerous looking commands in it, like
+// downloads piped to a shell. The hash also covers the files included with
+// #include directives, so editing one of them requires allowing again.
+//
+// The -shell flag selects the script syntax. Besides bash, zsh, and fish,
+// which `chenv init` supports, it also accepts pwsh and csh. The sections of
+// an envrc file are copied to the script as is, so they have to be written
+// for the same shell.
+//
+// With the -e flag every section is evaluated in a group and, if the group
+// fails, the rest of the script is skipped with `return`. A section fails when
+// its last command fails; this is the exit status of the group in bash, zsh,
+// and fish. The `return` relies on the script being evaluated inside a shell
+// function such as _chenv above. The default stays best-effort: a failing
+// section does not stop the ones after it. The -e flag is not supported for
+// pwsh and csh.
+//
+// The -root flag bounds the directories visited at the closest project root,
+// the closest parent with one of the named markers, so the envrc files above
+// it are not evaluated. The -project flag does the same with .git as the
+// marker.
+//
+// An envrc file that cannot be parsed is skipped with a warning on stderr, so
+// a single broken file does not stop the directory change. The -strict flag
+// makes it an error instead.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"strings"
 	"text/template"
 
@@ -25,14 +70,74 @@ import (
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: %s [flags] <src> <dst>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] allow [dir]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] init <shell>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] reload | export\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
 func main() {
-	flag.StringVar(&envrc.Name, "f", envrc.Name, "name of the envrc file")
+	names := flag.String("f", envrc.Name, "comma-separated names of the envrc file, the first existing one is used")
+	markers := flag.String("root", "", "comma-separated project root markers, such as .git, to stop at")
+	project := flag.Bool("project", false, "stop at the project root, marked by .git unless -root is given")
+	flag.BoolVar(&safe, "e", false, "stop evaluating after a failing section")
+	flag.StringVar(&shell, "shell", shell, "shell to write the script for (bash, zsh, fish, pwsh, or csh)")
+	flag.BoolVar(&dryRun, "n", false, "print the script as comments without evaluating it")
+	flag.StringVar(&format, "format", format, "output format (shell, export, or json)")
+	flag.BoolVar(&envrc.EvalSymlinks, "P", false, "resolve symbolic links in the paths, like cd -P")
+	flag.BoolVar(&strict, "strict", false, "fail on an envrc file that cannot be parsed instead of skipping it")
 	flag.Usage = usage
 	flag.Parse()
 
+	envrc.Names = split(*names)
+	switch {
+	case *markers != "":
+		envrc.Markers = split(*markers)
+	case *project:
+		envrc.Markers = []string{".git"}
+	}
+
+	if flag.Arg(0) == "allow" {
+		dir := "."
+		switch flag.NArg() {
+		case 1:
+		case 2:
+			dir = flag.Arg(1)
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := allow(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "chenv: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "init" {
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := initShell(os.Stdout, flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "chenv: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cmd := flag.Arg(0); cmd == "reload" || cmd == "export" {
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := reload(os.Stdout, ".", cmd == "reload"); err != nil {
+			fmt.Fprintf(os.Stderr, "chenv: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if flag.NArg() != 2 {
 		flag.Usage()
 		os.Exit(2)
@@ -46,26 +151,269 @@ func main() {
 	}
 }
 
-const text = `builtin pushd {{.Path}} >/dev/null 2>&1
-{{.Data}}
-builtin popd >/dev/null 2>&1
-` // Keep this last line in here!
+// safe selects the script template that stops after a failing section.
+var safe bool
+
+// shell is the name of the shell the script is written for.
+var shell = "bash"
+
+// dryRun writes the script as comments, along with the matched files.
+var dryRun bool
+
+// strict fails the directory change on a broken envrc file instead of
+// skipping it.
+var strict bool
+
+// format is the output format: a shell script, the exported variables as
+// KEY=VALUE lines, or the combined sections as JSON.
+var format = "shell"
+
+// hook is an enter or exit section to evaluate.
+type hook struct {
+	Path string
+	Data string
+	Exit bool
+	File *envrc.File // the file the section comes from
+}
+
+// walkFunc calls fn for every envrc file to evaluate, like envrc.WalkFiles.
+type walkFunc func(fn func(path string, f *envrc.File, exit bool)) error
+
+// split splits the comma-separated list s, dropping empty elements. An empty
+// name would match the directory itself.
+func split(s string) []string {
+	var elems []string
+	for _, e := range strings.Split(s, ",") {
+		if e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
 
+// chenv writes the script changing the environment from a to b.
 func chenv(w io.Writer, a, b string) error {
-	var buf strings.Builder
-	script := template.Must(template.New("script").Parse(text))
-	if err := envrc.Chdir(a, b, func(path, data string) {
-		if data != "" {
-			if e := script.Execute(&buf, struct {
-				Path string
-				Data string
-			}{path, data}); e != nil {
-				panic(e)
+	return run(w, a+" -> "+b, func(fn func(path string, f *envrc.File, exit bool)) error {
+		return envrc.WalkFiles(a, b, fn)
+	})
+}
+
+// reload writes the script evaluating the envrc file in dir again: the exit
+// section followed by the enter section, or only the enter section if exit is
+// false. Unlike chenv, the parent directories are not visited. The script is
+// empty if dir has no envrc file.
+func reload(w io.Writer, dir string, exit bool) error {
+	dir, err := envrc.Abs(dir)
+	if err != nil {
+		return err
+	}
+	return run(w, dir, func(fn func(path string, f *envrc.File, exit bool)) error {
+		f, err := envrc.Lookup(dir)
+		if err != nil {
+			if envrc.OnError != nil {
+				return envrc.OnError(dir, err)
 			}
+			return err
+		}
+		if f == nil {
+			return nil
+		}
+		if exit {
+			fn(dir, f, true)
+		}
+		fn(dir, f, false)
+		return nil
+	})
+}
+
+// run writes the script of the sections visited by walk in the selected
+// format. The title describes the change in dry-run comments.
+func run(w io.Writer, title string, walk walkFunc) error {
+	tmpl, ok := templates[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	t := tmpl.text
+	if safe {
+		if tmpl.safe == "" {
+			return fmt.Errorf("-e is not supported for %s", shell)
 		}
+		t = tmpl.safe
+	}
+	if dryRun && format != "shell" {
+		return fmt.Errorf("-n is not supported for the %s format", format)
+	}
+
+	list, err := loadAllowed()
+	if err != nil {
+		return err
+	}
+
+	var (
+		hooks   []hook
+		matched []string
+	)
+	envrc.OnError = nil
+	if !strict {
+		envrc.OnError = func(path string, err error) error {
+			fmt.Fprintf(os.Stderr, "# chenv: %v, skipping it\n", err)
+			if name, _ := envrc.Find(path); name != "" {
+				matched = append(matched, name+" (broken)")
+			}
+			return nil
+		}
+	}
+	if err := walk(func(path string, f *envrc.File, exit bool) {
+		es, xs := f.Eval()
+		data := es
+		if exit {
+			data = xs
+		}
+		if data == "" {
+			return
+		}
+		// The allow check hashes the source the sections were parsed from,
+		// so the emitted script is the one that was allowed.
+		if !list[allowEntry(f)] {
+			fmt.Fprintf(os.Stderr, "chenv: %s is not allowed, run `chenv allow %s` to allow it\n",
+				f.Path, path)
+			matched = append(matched, f.Path+" (not allowed)")
+			return
+		}
+		matched = append(matched, f.Path)
+		hooks = append(hooks, hook{path, data, exit, f})
 	}); err != nil {
 		return err
 	}
+
+	var buf strings.Builder
+	switch format {
+	case "shell":
+		script := template.Must(template.New("script").Funcs(template.FuncMap{
+			"quote": func(s string) string { return quote(shell, s) },
+		}).Parse(t))
+		for _, h := range hooks {
+			if err := script.Execute(&buf, h); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return comment(w, title, matched, buf.String())
+		}
+	case "export":
+		for _, h := range hooks {
+			if h.Exit {
+				continue
+			}
+			for _, a := range envrc.Assignments(h.Data) {
+				fmt.Fprintf(&buf, "%s=%s\n", a.Key, a.Value)
+			}
+		}
+	case "json":
+		var (
+			enter, exit []string
+			comments    []envrc.Comment
+		)
+		for _, h := range hooks {
+			section := "enter"
+			if h.Exit {
+				section = "exit"
+				exit = append(exit, h.Data)
+			} else {
+				enter = append(enter, h.Data)
+			}
+			comments = append(comments, sectionComments(h.File, section)...)
+		}
+		data, err := json.Marshal(struct {
+			Enter    string          `json:"enter"`
+			Exit     string          `json:"exit"`
+			Comments []envrc.Comment `json:"comments,omitempty"`
+		}{strings.Join(enter, "\n"), strings.Join(exit, "\n"), comments})
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+// sectionComments returns the trailing comments of the common lines and the
+// named section of f, including the included files.
+func sectionComments(f *envrc.File, section string) []envrc.Comment {
+	var comments []envrc.Comment
+	for _, c := range f.Comments {
+		if c.Section == "" || c.Section == section {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
+
+// initShell writes the integration snippet for shell to w. The snippet runs
+// this executable with the -e, -strict, -P, -f, and -root flags given to init.
+func initShell(w io.Writer, shell string) error {
+	text, ok := inits[shell]
+	if !ok {
+		return fmt.Errorf("no integration for shell: %s", shell)
+	}
+	if safe && templates[shell].safe == "" {
+		return fmt.Errorf("-e is not supported for %s", shell)
+	}
+
+	bin, err := os.Executable()
+	if err != nil {
+		if bin, err = exec.LookPath(os.Args[0]); err != nil {
+			return err
+		}
+	}
+
+	args := []string{quote(shell, bin), "-shell", shell}
+	if safe {
+		args = append(args, "-e")
+	}
+	if strict {
+		args = append(args, "-strict")
+	}
+	if envrc.EvalSymlinks {
+		args = append(args, "-P")
+	}
+	if f := strings.Join(envrc.Names, ","); f != "" && f != envrc.Name {
+		args = append(args, "-f", quote(shell, f))
+	}
+	if len(envrc.Markers) > 0 {
+		args = append(args, "-root", quote(shell, strings.Join(envrc.Markers, ",")))
+	}
+
+	return template.Must(template.New("init").Parse(text)).Execute(w, struct {
+		Bin string
+	}{strings.Join(args, " ")})
+}
+
+// comment writes the script for the change described by title as shell
+// comments, after the list of matched envrc files.
+func comment(w io.Writer, title string, matched []string, script string) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# chenv %s\n", title)
+	if len(matched) == 0 {
+		names := envrc.Names
+		if len(names) == 0 {
+			names = []string{envrc.Name}
+		}
+		fmt.Fprintf(&buf, "# no %s files matched\n", strings.Join(names, ", "))
+	}
+	for _, name := range matched {
+		fmt.Fprintf(&buf, "# matched %s\n", name)
+	}
+	if script != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(script, "\n"), "\n") {
+			fmt.Fprintf(&buf, "#   %s\n", line)
+		}
+	}
 	_, err := io.WriteString(w, buf.String())
 	return err
 }
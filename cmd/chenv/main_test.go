@@ -0,0 +1,345 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pxi/x/envrc"
+)
+
+// tree creates the envrc files of dirs, keyed by their directory relative to
+// a new root, and returns the root. The allow list is kept in a temporary
+// directory as well.
+func tree(t *testing.T, dirs map[string]string) string {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, data := range dirs {
+		write(t, filepath.Join(root, filepath.FromSlash(dir), ".envrc"), data)
+	}
+	return root
+}
+
+// write creates the file at path with data, along with its directory.
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// script returns the output of chenv for the change from a to b.
+func script(t *testing.T, a, b string) string {
+	t.Helper()
+	var buf strings.Builder
+	if err := chenv(&buf, a, b); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestAllowed(t *testing.T) {
+	root := tree(t, map[string]string{"a": "#include ../common\nenter:\necho a\n"})
+	write(t, filepath.Join(root, "common"), "A=1\n")
+	a := filepath.Join(root, "a")
+	want := "builtin pushd '" + a + "' >/dev/null 2>&1\nA=1\necho a\nbuiltin popd >/dev/null 2>&1\n"
+
+	if got := script(t, root, a); got != "" {
+		t.Errorf("not allowed:\n got %q\nwant empty", got)
+	}
+
+	if err := allow(a); err != nil {
+		t.Fatal(err)
+	}
+	if got := script(t, root, a); got != want {
+		t.Errorf("allowed:\n got %q\nwant %q", got, want)
+	}
+
+	write(t, filepath.Join(a, ".envrc"), "#include ../common\nenter:\necho b\n")
+	if got := script(t, root, a); got != "" {
+		t.Errorf("edited:\n got %q\nwant empty", got)
+	}
+
+	if err := allow(a); err != nil {
+		t.Fatal(err)
+	}
+	if got := script(t, root, a); !strings.Contains(got, "echo b\n") {
+		t.Errorf("allowed again:\n got %q\nwant echo b", got)
+	}
+
+	write(t, filepath.Join(root, "common"), "A=2\n")
+	if got := script(t, root, a); got != "" {
+		t.Errorf("included file edited:\n got %q\nwant empty", got)
+	}
+}
+
+func TestAllowedPartly(t *testing.T) {
+	root := tree(t, map[string]string{"a": "echo a\n", "a/b": "echo b\n"})
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "a", "b")
+	if err := allow(b); err != nil {
+		t.Fatal(err)
+	}
+
+	got := script(t, root, b)
+	if strings.Contains(got, "echo a") || !strings.Contains(got, "echo b") {
+		t.Errorf("only b is allowed:\n%s", got)
+	}
+
+	if err := allow(a); err != nil {
+		t.Fatal(err)
+	}
+	if got := script(t, root, b); !strings.Contains(got, "echo a") || !strings.Contains(got, "echo b") {
+		t.Errorf("both are allowed:\n%s", got)
+	}
+}
+
+func TestAllowMissing(t *testing.T) {
+	root := tree(t, nil)
+	if err := allow(root); err == nil {
+		t.Error("got no error for a directory without an envrc file")
+	}
+}
+
+func TestSafe(t *testing.T) {
+	root := tree(t, map[string]string{"a": "false\n", "c": "# settings\nenter:\n"})
+	a, c := filepath.Join(root, "a"), filepath.Join(root, "c")
+	for _, dir := range []string{a, c} {
+		if err := allow(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	safe = true
+	t.Cleanup(func() { safe, shell = false, "bash" })
+
+	want := "builtin pushd '" + c + "' >/dev/null 2>&1\n{ true\n# settings\n} || { builtin popd >/dev/null 2>&1; return 1; }\nbuiltin popd >/dev/null 2>&1\n"
+	if got := script(t, c, root); got != want {
+		t.Errorf("comments:\n got %q\nwant %q", got, want)
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Log("bash not found, skipping the syntax check")
+	} else if out, err := exec.Command("bash", "-n", "-c", want).CombinedOutput(); err != nil {
+		t.Errorf("comments: %v: %s", err, out)
+	}
+
+	want = "builtin pushd '" + a + "' >/dev/null 2>&1\n{ true\nfalse\n} || { builtin popd >/dev/null 2>&1; return 1; }\nbuiltin popd >/dev/null 2>&1\n"
+	if got := script(t, root, a); got != want {
+		t.Errorf("bash:\n got %q\nwant %q", got, want)
+	}
+
+	shell = "fish"
+	want = "set -l _chenv_pwd $PWD\nbuiltin cd '" + a + "'\nbegin\nfalse\nend; or begin; builtin cd $_chenv_pwd; return 1; end\nbuiltin cd $_chenv_pwd\n"
+	if got := script(t, root, a); got != want {
+		t.Errorf("fish:\n got %q\nwant %q", got, want)
+	}
+
+	for _, shell = range []string{"pwsh", "csh"} {
+		if err := chenv(new(strings.Builder), root, a); err == nil {
+			t.Errorf("%s: got no error", shell)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	root := tree(t, map[string]string{"a": "echo a\n", "a/b": "echo b\n"})
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "a", "b")
+	if err := allow(b); err != nil {
+		t.Fatal(err)
+	}
+	dryRun = true
+	t.Cleanup(func() { dryRun, format = false, "shell" })
+
+	want := "# chenv " + root + " -> " + b + "\n" +
+		"# matched " + filepath.Join(a, ".envrc") + " (not allowed)\n" +
+		"# matched " + filepath.Join(b, ".envrc") + "\n" +
+		"#   builtin pushd '" + b + "' >/dev/null 2>&1\n" +
+		"#   echo b\n" +
+		"#   builtin popd >/dev/null 2>&1\n"
+	if got := script(t, root, b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = "# chenv " + b + " -> " + b + "\n# no .envrc files matched\n"
+	if got := script(t, b, b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	format = "json"
+	if err := chenv(new(strings.Builder), root, b); err == nil {
+		t.Error("got no error for -n with the json format")
+	}
+}
+
+func TestInitShell(t *testing.T) {
+	bin, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := initShell(&buf, "bash"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "eval \"$('" + bin + "' -shell bash \"$OLDPWD\" \"$PWD\")\"\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("bash:\n%s\nwant a line with %q", buf.String(), want)
+	}
+	if want := "chenv_reload() { eval \"$('" + bin + "' -shell bash reload)\"; }\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("bash:\n%s\nwant a line with %q", buf.String(), want)
+	}
+
+	safe, strict, envrc.EvalSymlinks = true, true, true
+	envrc.Names, envrc.Markers = []string{".env", ".envrc"}, []string{".git"}
+	t.Cleanup(func() {
+		safe, strict, envrc.EvalSymlinks = false, false, false
+		envrc.Names, envrc.Markers = nil, nil
+	})
+
+	buf.Reset()
+	if err := initShell(&buf, "fish"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "eval ('" + bin + "' -shell fish -e -strict -P -f '.env,.envrc' -root '.git' $old $PWD | string collect)\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("fish:\n%s\nwant a line with %q", buf.String(), want)
+	}
+
+	for _, shell := range []string{"pwsh", "csh"} {
+		if err := initShell(new(strings.Builder), shell); err == nil {
+			t.Errorf("%s: got no error", shell)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a": "export A=1 # one\nB=\"two words\"\nX=1 make\nexit:\nunset A # drop A\n",
+		"b": "enter:\nC='3' # three\n",
+	})
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	for _, dir := range []string{a, b} {
+		if err := allow(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { format = "shell" })
+
+	format = "export"
+	if got, want := script(t, root, a), "A=1\nB=two words\n"; got != want {
+		t.Errorf("export:\n got %q\nwant %q", got, want)
+	}
+	if got, want := script(t, a, b), "C=3\n"; got != want {
+		t.Errorf("export:\n got %q\nwant %q", got, want)
+	}
+
+	format = "json"
+	want := `{"enter":"C='3' # three","exit":"export A=1 # one\nB=\"two words\"\nX=1 make\nunset A # drop A",` +
+		`"comments":[` +
+		`{"path":"` + filepath.Join(a, ".envrc") + `","line":1,"section":"","code":"export A=1","text":"one"},` +
+		`{"path":"` + filepath.Join(a, ".envrc") + `","line":5,"section":"exit","code":"unset A","text":"drop A"},` +
+		`{"path":"` + filepath.Join(b, ".envrc") + `","line":2,"section":"enter","code":"C='3'","text":"three"}]}` + "\n"
+	if got := script(t, a, b); got != want {
+		t.Errorf("json:\n got %s\nwant %s", got, want)
+	}
+
+	format = "yaml"
+	if err := chenv(new(strings.Builder), a, b); err == nil {
+		t.Error("got no error for an unsupported format")
+	}
+}
+
+func TestReload(t *testing.T) {
+	root := tree(t, map[string]string{"a": "enter:\necho in\nexit:\necho out\n", "a/b": "enter:\nenter:\n"})
+	a := filepath.Join(root, "a")
+	reloaded := func(dir string, exit bool) string {
+		t.Helper()
+		var buf strings.Builder
+		if err := reload(&buf, dir, exit); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	if got := reloaded(a, true); got != "" {
+		t.Errorf("not allowed:\n got %q\nwant empty", got)
+	}
+	if err := allow(a); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := func(s string) string {
+		return "builtin pushd '" + a + "' >/dev/null 2>&1\n" + s + "\nbuiltin popd >/dev/null 2>&1\n"
+	}
+	if got, want := reloaded(a, true), frame("echo out")+frame("echo in"); got != want {
+		t.Errorf("reload:\n got %q\nwant %q", got, want)
+	}
+	if got, want := reloaded(a, false), frame("echo in"); got != want {
+		t.Errorf("export:\n got %q\nwant %q", got, want)
+	}
+	if got := reloaded(root, true); got != "" {
+		t.Errorf("no envrc file:\n got %q\nwant empty", got)
+	}
+
+	b := filepath.Join(a, "b")
+	if got := reloaded(b, true); got != "" {
+		t.Errorf("broken:\n got %q\nwant empty", got)
+	}
+	strict = true
+	t.Cleanup(func() { strict = false })
+	if err := reload(new(strings.Builder), b, true); err == nil {
+		t.Error("got no error for a broken file with -strict")
+	}
+}
+
+func TestAllowSymlink(t *testing.T) {
+	root := tree(t, map[string]string{"real": "echo real\n"})
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(filepath.Join(root, "real"), link); err != nil {
+		t.Skip(err)
+	}
+	if err := allow(link); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { envrc.EvalSymlinks = false })
+
+	for _, envrc.EvalSymlinks = range []bool{false, true} {
+		if got := script(t, root, link); !strings.Contains(got, "echo real") {
+			t.Errorf("-P=%v:\n got %q\nwant echo real", envrc.EvalSymlinks, got)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		shell, s, want string
+	}{
+		{"bash", `it's $HOME`, `'it'\''s $HOME'`},
+		{"fish", `a\b'c`, `'a\\b\'c'`},
+		{"pwsh", "it's $HOME `n \"x\"", "'it''s $HOME `n \"x\"'"},
+	}
+	for _, c := range tests {
+		if got := quote(c.shell, c.s); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.shell, got, c.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for s, want := range map[string][]string{
+		".envrc":         {".envrc"},
+		".envrc,":        {".envrc"},
+		",.envrc,,.env,": {".envrc", ".env"},
+		",":              nil,
+	} {
+		if got := split(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, want %q", s, got, want)
+		}
+	}
+}
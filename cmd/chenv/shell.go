@@ -0,0 +1,95 @@
+package main
+
+import "strings"
+
+// templates are the script templates for each supported shell. The text
+// template runs every section; the safe template, if any, stops after the
+// first failing section and is selected with the -e flag. The quote function
+// quotes a path for the shell.
+var templates = map[string]struct {
+	text string
+	safe string
+}{
+	"bash": {posixText, posixSafeText},
+	"zsh":  {posixText, posixSafeText},
+	"fish": {fishText, fishSafeText},
+	"pwsh": {pwshText, ""},
+	"csh":  {cshText, ""},
+}
+
+const posixText = `builtin pushd {{quote .Path}} >/dev/null 2>&1
+{{.Data}}
+builtin popd >/dev/null 2>&1
+` // Keep this last line in here!
+
+// The group starts with true so that it is not empty, and thus valid, when
+// the section holds only comments.
+const posixSafeText = `builtin pushd {{quote .Path}} >/dev/null 2>&1
+{ true
+{{.Data}}
+} || { builtin popd >/dev/null 2>&1; return 1; }
+builtin popd >/dev/null 2>&1
+` // Keep this last line in here!
+
+const fishText = `set -l _chenv_pwd $PWD
+builtin cd {{quote .Path}}
+{{.Data}}
+builtin cd $_chenv_pwd
+` // Keep this last line in here!
+
+const fishSafeText = `set -l _chenv_pwd $PWD
+builtin cd {{quote .Path}}
+begin
+{{.Data}}
+end; or begin; builtin cd $_chenv_pwd; return 1; end
+builtin cd $_chenv_pwd
+` // Keep this last line in here!
+
+const pwshText = `Push-Location -LiteralPath {{quote .Path}}
+{{.Data}}
+Pop-Location
+` // Keep this last line in here!
+
+const cshText = `pushd {{quote .Path}} >& /dev/null
+{{.Data}}
+popd >& /dev/null
+` // Keep this last line in here!
+
+// inits are the shell integration snippets printed by `chenv init`. Bin is the
+// quoted command line used to run chenv.
+var inits = map[string]string{
+	"bash": posixInit,
+	"zsh":  posixInit,
+	"fish": fishInit,
+}
+
+const posixInit = `_chenv() {
+  builtin "$@" || return $?
+  eval "$({{.Bin}} "$OLDPWD" "$PWD")"
+}
+cd() { _chenv cd "$@"; }
+popd() { _chenv popd "$@"; }
+pushd() { _chenv pushd "$@"; }
+chenv_reload() { eval "$({{.Bin}} reload)"; }
+` // Keep this last line in here!
+
+const fishInit = `function _chenv
+  set -l old $PWD
+  builtin $argv; or return $status
+  eval ({{.Bin}} $old $PWD | string collect)
+end
+function cd; _chenv cd $argv; end
+function chenv_reload; eval ({{.Bin}} reload | string collect); end
+` // Keep this last line in here!
+
+// quote returns s quoted as a single word for the shell.
+func quote(shell, s string) string {
+	switch shell {
+	case "fish":
+		s = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+		return "'" + s + "'"
+	case "pwsh":
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
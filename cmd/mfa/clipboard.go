@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboards are the commands tried in order for writing to the clipboard.
+var clipboards = map[string][][]string{
+	"darwin": {{"pbcopy"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	},
+	"windows": {{"clip"}},
+}
+
+// clipboard places text on the clipboard. Tests replace it to capture the
+// copied text.
+var clipboard = systemClipboard
+
+// systemClipboard places text on the system clipboard using the first
+// available command of clipboards.
+func systemClipboard(text string) error {
+	for _, args := range clipboards[runtime.GOOS] {
+		if args[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard command found")
+}
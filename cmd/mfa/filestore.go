@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The file store keeps the secrets of all accounts in a single file encrypted
+// with AES-256-GCM. The key is derived from a passphrase with PBKDF2-SHA256.
+// The file holds the salt, followed by the nonce and the sealed JSON object
+// mapping account names to secrets.
+//
+// The file store is used instead of the system keychain when MFA_STORE is set
+// to "file". The passphrase is read from MFA_PASSPHRASE or prompted for on the
+// terminal.
+
+const (
+	fileSaltSize   = 16
+	fileIterations = 600000
+)
+
+// filePath returns the path of the secrets file for service.
+func filePath(service string) (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, service, "secrets"), nil
+}
+
+func fileSecret(service, account string) (string, error) {
+	secrets, _, err := readFile(service)
+	if err != nil {
+		return "", err
+	}
+	s, ok := secrets[account]
+	if !ok {
+		return "", fmt.Errorf("no secret for %s", account)
+	}
+	return s, nil
+}
+
+func fileAddSecret(service, account, secret string) error {
+	secrets, pass, err := readFile(service)
+	if err != nil {
+		return err
+	}
+	secrets[account] = secret
+	return writeFile(service, pass, secrets)
+}
+
+func fileAccounts(service string) ([]string, error) {
+	secrets, _, err := readFile(service)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for account := range secrets {
+		names = append(names, account)
+	}
+	return names, nil
+}
+
+// readFile decrypts the secrets file of service. A missing file is treated as
+// an empty one. The passphrase is returned for writing the file back.
+func readFile(service string) (map[string]string, string, error) {
+	path, err := filePath(service)
+	if err != nil {
+		return nil, "", err
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, pass, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	aead, err := fileCipher(pass, data)
+	if err != nil {
+		return nil, "", err
+	}
+	data = data[fileSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, "", fmt.Errorf("%s: file is truncated", path)
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: wrong passphrase or corrupted file", path)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	return secrets, pass, nil
+}
+
+// writeFile encrypts secrets into the secrets file of service with a fresh
+// salt and nonce.
+func writeFile(service, pass string, secrets map[string]string) error {
+	path, err := filePath(service)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, fileSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := fileCipher(pass, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, plain, nil)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fileCipher returns the AEAD for the passphrase and the salt at the start
+// of data.
+func fileCipher(pass string, data []byte) (cipher.AEAD, error) {
+	if len(data) < fileSaltSize {
+		return nil, errors.New("secrets file is truncated")
+	}
+	key, err := pbkdf2.Key(sha256.New, pass, data[:fileSaltSize], fileIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphrase returns the passphrase of the secrets file from MFA_PASSPHRASE,
+// or prompts for it on the terminal.
+func passphrase() (string, error) {
+	if pass := os.Getenv("MFA_PASSPHRASE"); pass != "" {
+		return pass, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no passphrase: MFA_PASSPHRASE is not set and there is no terminal")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, "Passphrase: ")
+	if stty(tty, "-echo") == nil {
+		defer func() {
+			stty(tty, "echo")
+			fmt.Fprintln(tty)
+		}()
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return pass, nil
+}
+
+// stty changes the terminal settings of tty.
+func stty(tty *os.File, args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
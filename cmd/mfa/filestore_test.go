@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("MFA_PASSPHRASE", "correct horse")
+
+	if err := fileAddSecret(service, "alice", "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileAddSecret(service, "bob", "GEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	for account, want := range map[string]string{
+		"alice": "JBSWY3DPEHPK3PXP",
+		"bob":   "GEZDGNBVGY3TQOJQ",
+	} {
+		got, err := fileSecret(service, account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", account, got, want)
+		}
+	}
+
+	if _, err := fileSecret(service, "carol"); err == nil {
+		t.Error("missing account succeeded")
+	}
+
+	path, err := filePath(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0o600 {
+		t.Errorf("file mode: got %v, want 0600", fi.Mode().Perm())
+	}
+
+	t.Setenv("MFA_PASSPHRASE", "wrong")
+	if _, err := fileSecret(service, "alice"); err == nil {
+		t.Error("wrong passphrase succeeded")
+	}
+}
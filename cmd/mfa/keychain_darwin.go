@@ -3,6 +3,18 @@ package main
 // #cgo LDFLAGS: -framework CoreFoundation -framework Security
 // #include <CoreFoundation/CoreFoundation.h>
 // #include <Security/Security.h>
+//
+// static CFDictionaryRef accountsQuery(CFStringRef service) {
+// 	const void *keys[] = {kSecClass, kSecAttrService, kSecMatchLimit, kSecReturnAttributes};
+// 	const void *values[] = {kSecClassGenericPassword, service, kSecMatchLimitAll, kCFBooleanTrue};
+// 	return CFDictionaryCreate(NULL, keys, values, 4,
+// 		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+// }
+//
+// static CFStringRef accountAt(CFArrayRef items, CFIndex i) {
+// 	CFDictionaryRef item = CFArrayGetValueAtIndex(items, i);
+// 	return CFDictionaryGetValue(item, kSecAttrAccount);
+// }
 import "C"
 
 import (
@@ -31,14 +43,117 @@ func secret(service, account string) (string, error) {
 		&cPass,
 		nil,
 	); ret != C.errSecSuccess {
-		cMsg := C.SecCopyErrorMessageString(ret, nil)
-		defer C.CFRelease(C.CFTypeRef(cMsg))
-		cStr := C.CFStringGetCStringPtr(cMsg, C.kCFStringEncodingUTF8)
-		if cStr != nil {
-			return "", errors.New(C.GoString(cStr))
-		}
-		return "", fmt.Errorf("unknown error: %d", ret)
+		return "", keychainError(ret)
 	}
 
 	return C.GoStringN((*C.char)(cPass), C.int(cSize)), nil
 }
+
+func addSecret(service, account, secret string) error {
+	cService := C.CString(service)
+	cAccount := C.CString(account)
+	cSecret := C.CString(secret)
+
+	defer C.free(unsafe.Pointer(cService))
+	defer C.free(unsafe.Pointer(cAccount))
+	defer C.free(unsafe.Pointer(cSecret))
+
+	ret := C.SecKeychainAddGenericPassword(
+		0, // default keychain
+		C.UInt32(len(service)),
+		cService,
+		C.UInt32(len(account)),
+		cAccount,
+		C.UInt32(len(secret)),
+		unsafe.Pointer(cSecret),
+		nil,
+	)
+	if ret == C.errSecSuccess {
+		return nil
+	}
+	if ret != C.errSecDuplicateItem {
+		return keychainError(ret)
+	}
+
+	// The account already exists, update its secret instead.
+	var cItem C.SecKeychainItemRef
+	if ret := C.SecKeychainFindGenericPassword(
+		0, // default keychain
+		C.UInt32(len(service)),
+		cService,
+		C.UInt32(len(account)),
+		cAccount,
+		nil,
+		nil,
+		&cItem,
+	); ret != C.errSecSuccess {
+		return keychainError(ret)
+	}
+	defer C.CFRelease(C.CFTypeRef(cItem))
+
+	if ret := C.SecKeychainItemModifyAttributesAndData(
+		cItem,
+		nil, // keep attributes
+		C.UInt32(len(secret)),
+		unsafe.Pointer(cSecret),
+	); ret != C.errSecSuccess {
+		return keychainError(ret)
+	}
+
+	return nil
+}
+
+func accounts(service string) ([]string, error) {
+	cService := C.CString(service)
+	defer C.free(unsafe.Pointer(cService))
+
+	cfService := C.CFStringCreateWithCString(C.kCFAllocatorDefault, cService, C.kCFStringEncodingUTF8)
+	defer C.CFRelease(C.CFTypeRef(cfService))
+
+	query := C.accountsQuery(cfService)
+	defer C.CFRelease(C.CFTypeRef(query))
+
+	var result C.CFTypeRef
+	ret := C.SecItemCopyMatching(query, &result)
+	if ret == C.errSecItemNotFound {
+		return nil, nil
+	}
+	if ret != C.errSecSuccess {
+		return nil, keychainError(ret)
+	}
+	defer C.CFRelease(result)
+
+	var names []string
+	items := C.CFArrayRef(result)
+	for i := C.CFIndex(0); i < C.CFArrayGetCount(items); i++ {
+		if cfAccount := C.accountAt(items, i); cfAccount != 0 {
+			names = append(names, goString(cfAccount))
+		}
+	}
+	return names, nil
+}
+
+// goString returns the Go string of a CFString.
+func goString(s C.CFStringRef) string {
+	if cStr := C.CFStringGetCStringPtr(s, C.kCFStringEncodingUTF8); cStr != nil {
+		return C.GoString(cStr)
+	}
+	n := C.CFStringGetMaximumSizeForEncoding(C.CFStringGetLength(s), C.kCFStringEncodingUTF8) + 1
+	cBuf := (*C.char)(C.malloc(C.size_t(n)))
+	defer C.free(unsafe.Pointer(cBuf))
+	if C.CFStringGetCString(s, cBuf, n, C.kCFStringEncodingUTF8) == 0 {
+		return ""
+	}
+	return C.GoString(cBuf)
+}
+
+// keychainError returns an error with the message of the status code ret.
+func keychainError(ret C.OSStatus) error {
+	cMsg := C.SecCopyErrorMessageString(ret, nil)
+	defer C.CFRelease(C.CFTypeRef(cMsg))
+	cStr := C.CFStringGetCStringPtr(cMsg, C.kCFStringEncodingUTF8)
+	if cStr != nil {
+		return errors.New(C.GoString(cStr))
+	}
+	return fmt.Errorf("unknown error: %d", ret)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service API (org.freedesktop.secrets) is accessed through the
+// secret-tool command of libsecret. Items are matched by their service and
+// account attributes, which keeps them grouped like on the darwin keychain.
+
+func secret(service, account string) (string, error) {
+	out, err := secretTool(nil, "lookup", "service", service, "account", account)
+	if err != nil {
+		return "", err
+	}
+	if out == "" {
+		return "", fmt.Errorf("no secret for %s", account)
+	}
+	return out, nil
+}
+
+func addSecret(service, account, secret string) error {
+	_, err := secretTool(strings.NewReader(secret),
+		"store", "--label", service+": "+account,
+		"service", service, "account", account)
+	return err
+}
+
+// accounts lists the accounts of service. The search of secret-tool always
+// loads the secrets and prints them to stdout, while the attributes go to
+// stdout or, with newer versions of libsecret, to stderr, so both streams are
+// scanned for account attributes, dropping every other line as it is written.
+func accounts(service string) ([]string, error) {
+	var stdout, stderr accountLines
+	if err := runSecretTool(nil, &stdout, &stderr, "search", "--all", "service", service); err != nil {
+		return nil, err
+	}
+	return append(stdout.accounts(), stderr.accounts()...), nil
+}
+
+// accountLines is a writer of secret-tool search output that keeps the
+// values of the account attributes, and nothing else.
+type accountLines struct {
+	names []string
+	line  []byte // the incomplete last line
+}
+
+func (a *accountLines) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			a.line = append(a.line, p...)
+			return n, nil
+		}
+		a.add(append(a.line, p[:i]...))
+		a.line = a.line[:0]
+		p = p[i+1:]
+	}
+}
+
+// add adds the account of line, if it is an account attribute.
+func (a *accountLines) add(line []byte) {
+	k, v, ok := strings.Cut(strings.TrimSpace(string(line)), " = ")
+	if ok && k == "attribute.account" {
+		a.names = append(a.names, v)
+	}
+}
+
+// accounts returns the accounts of the lines written to a.
+func (a *accountLines) accounts() []string {
+	if len(a.line) > 0 {
+		a.add(a.line)
+		a.line = nil
+	}
+	return a.names
+}
+
+// secretTool runs secret-tool with args and returns its trimmed output.
+func secretTool(stdin io.Reader, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	if err := runSecretTool(stdin, &stdout, nil, args...); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// runSecretTool runs secret-tool with args, writing its output to stdout and,
+// if not nil, stderr.
+func runSecretTool(stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return errors.New("no D-Bus session bus: DBUS_SESSION_BUS_ADDRESS is not set")
+	}
+
+	var msg bytes.Buffer
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &msg
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(&msg, stderr)
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(msg.String()); msg != "" {
+			return fmt.Errorf("secret-tool: %s", msg)
+		}
+		if _, ok := err.(*exec.ExitError); ok && (args[0] == "lookup" || args[0] == "search") {
+			// Lookup and search exit non-zero without output when no
+			// items match.
+			return nil
+		}
+		return fmt.Errorf("secret-tool: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The output of `secret-tool search --all service mfa` with libsecret 0.20,
+// which writes the attributes to stderr.
+const (
+	searchStdout = `[/org/freedesktop/secrets/collection/login/3]
+label = mfa: alice
+secret = JBSWY3DPEHPK3PXP
+created = 2026-10-15 09:12:41
+modified = 2026-10-15 09:12:41
+[/org/freedesktop/secrets/collection/login/4]
+label = mfa: bob
+secret = GEZDGNBVGY3TQOJQ
+created = 2026-10-15 09:13:02
+modified = 2026-10-15 09:13:02
+`
+	searchStderr = `attribute.service = mfa
+attribute.account = alice
+attribute.account = bob
+attribute.service = mfa
+`
+)
+
+func TestAccountLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{"stdout", searchStdout, nil},
+		{"stderr", searchStderr, []string{"alice", "bob"}},
+		// Older versions write everything to stdout.
+		{"both", searchStdout + searchStderr, []string{"alice", "bob"}},
+	}
+
+	for _, c := range tests {
+		var a accountLines
+		// Write in small chunks, like from a pipe, splitting lines.
+		for p := []byte(c.output); len(p) > 0; {
+			n := min(len(p), 7)
+			a.Write(p[:n])
+			p = p[n:]
+		}
+		if got := a.accounts(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
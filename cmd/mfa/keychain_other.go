@@ -0,0 +1,15 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "errors"
+
+// errNoKeychain is returned on systems without a supported keychain.
+var errNoKeychain = errors.New("no keychain on this system, set MFA_STORE=file")
+
+func secret(service, account string) (string, error) { return "", errNoKeychain }
+
+func addSecret(service, account, secret string) error { return errNoKeychain }
+
+func accounts(service string) ([]string, error) { return nil, errNoKeychain }
@@ -1,61 +1,361 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base32"
-	"encoding/binary"
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/pxi/x/otp"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] <account>...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s add <account> < secret\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s add <otpauth-uri> [account]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s list\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
 func main() {
-	if err := print(os.Stderr, os.Args[1:]...); err != nil {
+	flag.Int64Var(&counter, "c", -1, "use HOTP with the given counter instead of TOTP")
+	flag.IntVar(&opts.digits, "d", opts.digits, "number of digits in the code (6 or 8)")
+	flag.Int64Var(&opts.period, "p", opts.period, "TOTP period in seconds")
+	flag.StringVar(&opts.algorithm, "a", opts.algorithm, "HMAC algorithm (sha1, sha256, or sha512)")
+	flag.BoolVar(&remain, "t", false, "print the seconds remaining in the TOTP period")
+	flag.BoolVar(&wait, "w", false, "wait for the next TOTP period if less than 3 seconds remain")
+	flag.BoolVar(&clip, "copy", false, "copy the code to the clipboard instead of printing it")
+	flag.BoolVar(&fromEnv, "e", false, "read the secrets from MFA_SECRET_<ACCOUNT> environment variables")
+	flag.BoolVar(&labels, "l", false, "prefix the codes with the account names, the default on a terminal")
+	flag.IntVar(&window, "window", 0, "also print the codes of the `N` periods, or counter values, before and after")
+	flag.Usage = usage
+	flag.Parse()
+
+	if window < 0 {
+		fmt.Fprintf(os.Stderr, "mfa: invalid window: %d\n", window)
+		os.Exit(2)
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "mfa: %v\n", err)
+		os.Exit(2)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "add":
+		// An account name comes alone, with the secret read from stdin.
+		uri := strings.HasPrefix(flag.Arg(1), "otpauth://")
+		if flag.NArg() < 2 || flag.NArg() > 3 || !uri && flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = add(os.Stderr, os.Stdin, flag.Args()[1:]...)
+	case "list":
+		err = list(os.Stdout)
+	default:
+		err = print(os.Stdout, flag.Args()...)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "mfa: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// counter is the HOTP counter value. TOTP is used when it is negative.
+var counter int64 = -1
+
+// remain and wait control the handling of the time left in a TOTP period.
+var remain, wait bool
+
+// labels prefixes every code with its account name. It is the default when
+// the codes are written to a terminal, but not to the clipboard.
+var labels bool
+
+// window is the number of codes printed before and after the current one,
+// for trying an adjacent code against a server with clock drift. With a
+// window, every code is labeled with its offset from the current one.
+var window int
+
+// clip copies the codes to the clipboard instead of printing them. Only the
+// bare codes are copied, ready for pasting; the lines of codes with a label,
+// offset, or remaining time are still printed to show those. The clipboard is
+// not cleared afterwards.
+var clip bool
+
+// otpOptions are the parameters shared by the HOTP and TOTP computations.
+type otpOptions struct {
+	digits    int    // number of digits in the code
+	period    int64  // TOTP period in seconds
+	algorithm string // name of the HMAC hash
+}
+
+// opts are the options set from the command line.
+var opts = otpOptions{digits: 6, period: 30, algorithm: "sha1"}
+
+func (o otpOptions) validate() error {
+	if o.digits != 6 && o.digits != 8 {
+		return fmt.Errorf("invalid number of digits: %d", o.digits)
+	}
+	if o.period <= 0 {
+		return fmt.Errorf("invalid period: %d", o.period)
+	}
+	switch o.algorithm {
+	case "sha1", "sha256", "sha512":
+	default:
+		return fmt.Errorf("unknown algorithm: %s", o.algorithm)
+	}
+	return nil
+}
+
+// options returns o as options of the otp package.
+func (o otpOptions) options() []otp.Option {
+	return []otp.Option{
+		otp.Digits(o.digits),
+		otp.Period(time.Duration(o.period) * time.Second),
+		otp.Algorithm(o.algorithm),
+	}
+}
+
 // service is used to identify this service when interacting with the keychain.
 const service = "mfa"
 
+// fileStore reports whether the encrypted file store is used instead of the
+// system keychain.
+func fileStore() bool { return os.Getenv("MFA_STORE") == "file" }
+
+// fromEnv reads the secrets from the environment instead of the selected
+// store. See envSecret.
+var fromEnv bool
+
+// lookup returns the secret of account from the selected store. If the store
+// fails, or fromEnv is set, the secret is read from the environment.
+func lookup(account string) (string, error) {
+	if fromEnv {
+		if s, ok := envSecret(account); ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("%s is not set", envName(account))
+	}
+
+	var (
+		s   string
+		err error
+	)
+	if fileStore() {
+		s, err = fileSecret(service, account)
+	} else {
+		s, err = secret(service, account)
+	}
+	if err != nil {
+		if s, ok := envSecret(account); ok {
+			return s, nil
+		}
+	}
+	return s, err
+}
+
+// envSecret returns the secret of account from the environment variable
+// named by envName, for headless use such as in CI jobs. Unlike the stores,
+// the environment is inherited by every child process and readable by other
+// processes of the same user, so it should only hold the secrets of
+// short-lived environments.
+func envSecret(account string) (string, bool) {
+	s := os.Getenv(envName(account))
+	return s, s != ""
+}
+
+// envName returns the name of the environment variable holding the secret of
+// account: MFA_SECRET_ followed by the account name in upper case, with every
+// character other than a letter or digit replaced by an underscore.
+func envName(account string) string {
+	return "MFA_SECRET_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, account)
+}
+
+// list writes the sorted account names of the selected store to w.
+func list(w io.Writer) error {
+	var (
+		names []string
+		err   error
+	)
+	if fileStore() {
+		names, err = fileAccounts(service)
+	} else {
+		names, err = accounts(service)
+	}
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+	return nil
+}
+
+// store saves the secret of account in the selected store.
+func store(account, s string) error {
+	if fileStore() {
+		return fileAddSecret(service, account, s)
+	}
+	return addSecret(service, account, s)
+}
+
+// now returns the current time. Tests replace it to get known codes.
+var now = time.Now
+
+// print writes the codes of the accounts to w, or to the clipboard.
 func print(w io.Writer, accounts ...string) error {
-	for _, account := range accounts {
-		s, err := secret(service, account)
-		if err != nil {
+	left, err := otp.Remaining(now(), opts.options()...)
+	if err != nil {
+		return err
+	}
+	if counter < 0 && wait && left < 3*time.Second {
+		time.Sleep(left)
+		if left, err = otp.Remaining(now(), opts.options()...); err != nil {
 			return err
 		}
-		n, err := totp(s, now())
+	}
+
+	label := labels || !clip && isTerminal(w)
+	var buf strings.Builder // the codes copied to the clipboard
+
+	c := counter
+	if c < 0 {
+		if c, err = otp.Counter(now(), opts.options()...); err != nil {
+			return err
+		}
+	}
+
+	for _, account := range accounts {
+		s, err := lookup(account)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%06d\n", n)
+		for i := -window; i <= window; i++ {
+			n := c + int64(i)
+			if n < 0 {
+				continue
+			}
+			code, err := otp.HOTP(s, n, opts.options()...)
+			if err != nil {
+				return err
+			}
+			line := code
+			if label {
+				line = account + ": " + line
+			}
+			if window > 0 {
+				line += fmt.Sprintf(" %+d", i)
+			}
+			if counter < 0 && remain && i == 0 {
+				line += fmt.Sprintf(" %ds", left/time.Second)
+			}
+			if !clip {
+				fmt.Fprintln(w, line)
+				continue
+			}
+			fmt.Fprintln(&buf, code)
+			if line != code {
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+
+	if clip {
+		return clipboard(strings.TrimSuffix(buf.String(), "\n"))
 	}
 	return nil
 }
 
-// now returns a TOTP challenge for now.
-func now() int64 { return int64(time.Now().Unix() / 30) }
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
-// totp computes the response code for a challenge using the secret.
-func totp(secret string, c int64) (int, error) {
-	k, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
-	if err != nil {
-		return -1, err
+// add stores a secret in the selected store. The arguments are either an
+// otpauth URI and an optional account name overriding its label, or just an
+// account name, in which case the secret is read from the first line of r.
+// Reading from r keeps the secret out of the shell history. The line may also
+// hold an otpauth URI. Existing accounts are updated. Since only the secret is
+// stored, the flags needed for non-default parameters, including the counter
+// of an HOTP secret, are written to w.
+func add(w io.Writer, r io.Reader, args ...string) error {
+	var uri, account string
+	if strings.HasPrefix(args[0], "otpauth://") {
+		uri = args[0]
+		if len(args) > 1 {
+			account = args[1]
+		}
+	} else {
+		account = args[0]
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		uri = strings.TrimSpace(line)
 	}
 
-	hash := hmac.New(sha1.New, k)
-	if err := binary.Write(hash, binary.BigEndian, c); err != nil {
-		return -1, err
+	var (
+		s   string
+		o   = otpOptions{digits: 6, period: 30, algorithm: "sha1"}
+		c   = int64(-1) // the HOTP counter, or -1 for TOTP
+		err error
+	)
+	if strings.HasPrefix(uri, "otpauth://") {
+		var label string
+		if label, s, o, c, err = parseOTPAuth(uri); err != nil {
+			return err
+		}
+		if account == "" {
+			account = label
+		}
+	} else {
+		s = uri
+		if s == "" {
+			return fmt.Errorf("no secret for %s", account)
+		}
+		if _, err := otp.DecodeSecret(s); err != nil {
+			return err
+		}
 	}
 
-	p := hash.Sum(nil)
-	i := p[19] & 0x0f
-	n := binary.BigEndian.Uint32(p[i : i+4])
-	n &= 0x7fffffff
+	if err := store(account, s); err != nil {
+		return err
+	}
 
-	return int(n % 1000000), nil
+	var flags []string
+	if c >= 0 {
+		flags = append(flags, fmt.Sprintf("-c %d", c))
+	}
+	if o.digits != 6 {
+		flags = append(flags, fmt.Sprintf("-d %d", o.digits))
+	}
+	if c < 0 && o.period != 30 {
+		flags = append(flags, fmt.Sprintf("-p %d", o.period))
+	}
+	if o.algorithm != "sha1" {
+		flags = append(flags, "-a "+o.algorithm)
+	}
+	fmt.Fprintf(w, "added %s\n", account)
+	if len(flags) > 0 {
+		fmt.Fprintf(w, "use %s when generating codes for %s\n", strings.Join(flags, " "), account)
+	}
+	return nil
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		account string
+		want    string
+	}{
+		{"alice", "MFA_SECRET_ALICE"},
+		{"GitHub", "MFA_SECRET_GITHUB"},
+		{"Example:alice@example.com", "MFA_SECRET_EXAMPLE_ALICE_EXAMPLE_COM"},
+		{"aws-prod 2", "MFA_SECRET_AWS_PROD_2"},
+		{"ålice", "MFA_SECRET__LICE"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := envName(c.account); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLookupEnv(t *testing.T) {
+	t.Setenv("MFA_STORE", "file")
+	t.Setenv("MFA_PASSPHRASE", "test") // not prompted for on the terminal
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("MFA_SECRET_ALICE", "JBSWY3DPEHPK3PXP")
+
+	// The empty file store fails, so the environment is used.
+	s, err := lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("got %q, want %q", s, "JBSWY3DPEHPK3PXP")
+	}
+
+	defer func(b bool) { fromEnv = b }(fromEnv)
+	fromEnv = true
+	if _, err := lookup("bob"); err == nil {
+		t.Error("got no error for an unset variable")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	// The RFC 6238 SHA-1 seed, "12345678901234567890" in base32.
+	t.Setenv("MFA_SECRET_ALICE", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	t.Setenv("MFA_SECRET_BOB", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(59, 0) }
+	defer func(e, l, r bool, n int, c int64) {
+		fromEnv, labels, remain, window, counter = e, l, r, n, c
+	}(fromEnv, labels, remain, window, counter)
+	fromEnv = true
+
+	tests := []struct {
+		setup    func()
+		accounts []string
+		want     string
+	}{
+		{func() {}, []string{"alice"}, "287082\n"},
+		{func() { remain = true }, []string{"alice"}, "287082 1s\n"},
+		{func() { labels = true }, []string{"alice", "bob"}, "alice: 287082\nbob: 287082\n"},
+		{func() { window = 1 }, []string{"alice"}, "755224 -1\n287082 +0\n359152 +1\n"},
+		{func() { counter = 0; window = 1 }, []string{"alice"}, "755224 +0\n287082 +1\n"},
+		{func() { counter = 8 }, []string{"alice"}, "399871\n"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			labels, remain, window, counter = false, false, 0, -1
+			c.setup()
+			var buf bytes.Buffer
+			if err := print(&buf, c.accounts...); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPrintCopy(t *testing.T) {
+	t.Setenv("MFA_SECRET_ALICE", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(59, 0) }
+	defer func(f func(string) error) { clipboard = f }(clipboard)
+	var copied string
+	clipboard = func(text string) error {
+		copied = text
+		return nil
+	}
+	defer func(e, l, r, c bool, n int) {
+		fromEnv, labels, remain, clip, window = e, l, r, c, n
+	}(fromEnv, labels, remain, clip, window)
+	fromEnv, clip = true, true
+
+	tests := []struct {
+		setup  func()
+		want   string
+		copied string
+	}{
+		{func() {}, "", "287082"},
+		{func() { remain = true }, "287082 1s\n", "287082"},
+		{func() { labels = true }, "alice: 287082\n", "287082"},
+		{func() { window = 1 }, "755224 -1\n287082 +0\n359152 +1\n", "755224\n287082\n359152"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			labels, remain, window, copied = false, false, 0, ""
+			c.setup()
+			var buf bytes.Buffer
+			if err := print(&buf, "alice"); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("printed %q, want %q", got, c.want)
+			}
+			if copied != c.copied {
+				t.Errorf("copied %q, want %q", copied, c.copied)
+			}
+		})
+	}
+}
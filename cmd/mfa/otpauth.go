@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pxi/x/otp"
+)
+
+// parseOTPAuth parses an otpauth URI as encoded in the QR codes of most
+// providers, for example:
+//
+//	otpauth://totp/Issuer:account?secret=...&digits=8&period=30&algorithm=SHA256
+//
+// The returned account is the label of the URI. Parameters missing from the
+// URI keep their default values. The counter of an hotp URI is returned, or
+// -1 for a totp URI.
+func parseOTPAuth(uri string) (account, secret string, o otpOptions, counter int64, err error) {
+	o = otpOptions{digits: 6, period: 30, algorithm: "sha1"}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", o, -1, err
+	}
+	if u.Scheme != "otpauth" {
+		return "", "", o, -1, fmt.Errorf("not an otpauth URI: %s", uri)
+	}
+	if u.Host != "totp" && u.Host != "hotp" {
+		return "", "", o, -1, fmt.Errorf("unsupported otpauth type: %s", u.Host)
+	}
+
+	account = strings.TrimPrefix(u.Path, "/")
+	if account == "" {
+		return "", "", o, -1, fmt.Errorf("otpauth URI has no label")
+	}
+
+	q := u.Query()
+	secret = q.Get("secret")
+	if secret == "" {
+		return "", "", o, -1, fmt.Errorf("otpauth URI has no secret")
+	}
+	if _, err := otp.DecodeSecret(secret); err != nil {
+		return "", "", o, -1, err
+	}
+
+	if s := q.Get("digits"); s != "" {
+		if o.digits, err = strconv.Atoi(s); err != nil {
+			return "", "", o, -1, fmt.Errorf("invalid digits: %s", s)
+		}
+	}
+	if s := q.Get("period"); s != "" {
+		if o.period, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return "", "", o, -1, fmt.Errorf("invalid period: %s", s)
+		}
+	}
+	if s := q.Get("algorithm"); s != "" {
+		o.algorithm = strings.ToLower(s)
+	}
+	counter = -1
+	if u.Host == "hotp" {
+		s := q.Get("counter")
+		if counter, err = strconv.ParseInt(s, 10, 64); err != nil || counter < 0 {
+			return "", "", o, -1, fmt.Errorf("invalid counter: %q", s)
+		}
+	}
+
+	return account, secret, o, counter, o.validate()
+}
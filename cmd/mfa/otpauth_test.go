@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseOTPAuth(t *testing.T) {
+	tests := []struct {
+		uri     string
+		account string
+		secret  string
+		opts    otpOptions
+		counter int64
+	}{
+		{
+			"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP",
+			"alice", "JBSWY3DPEHPK3PXP", otpOptions{6, 30, "sha1"}, -1,
+		},
+		{
+			"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example&digits=8&period=60&algorithm=SHA256",
+			"Example:alice@example.com", "JBSWY3DPEHPK3PXP", otpOptions{8, 60, "sha256"}, -1,
+		},
+		{
+			"otpauth://hotp/bob?secret=jbswy3dpehpk3pxp&counter=1",
+			"bob", "jbswy3dpehpk3pxp", otpOptions{6, 30, "sha1"}, 1,
+		},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			account, secret, opts, counter, err := parseOTPAuth(c.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if account != c.account {
+				t.Errorf("account: got %q, want %q", account, c.account)
+			}
+			if secret != c.secret {
+				t.Errorf("secret: got %q, want %q", secret, c.secret)
+			}
+			if opts != c.opts {
+				t.Errorf("options: got %+v, want %+v", opts, c.opts)
+			}
+			if counter != c.counter {
+				t.Errorf("counter: got %d, want %d", counter, c.counter)
+			}
+		})
+	}
+}
+
+func TestParseOTPAuthInvalid(t *testing.T) {
+	tests := []string{
+		"https://totp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/alice",
+		"otpauth://totp/alice?secret=not-base32!",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=7",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP&counter=-1",
+	}
+
+	for i, uri := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, _, _, _, err := parseOTPAuth(uri); err == nil {
+				t.Errorf("parseOTPAuth(%q) succeeded", uri)
+			}
+		})
+	}
+}
+
+func TestAddHint(t *testing.T) {
+	t.Setenv("MFA_STORE", "file")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("MFA_PASSPHRASE", "correct horse")
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP", "added alice\n"},
+		{"otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP&digits=8&period=60",
+			"added bob\nuse -d 8 -p 60 when generating codes for bob\n"},
+		{"otpauth://hotp/carol?secret=JBSWY3DPEHPK3PXP&counter=5&period=60",
+			"added carol\nuse -c 5 when generating codes for carol\n"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var buf strings.Builder
+			if err := add(&buf, nil, c.uri); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package envrc
+
+import (
+	"io"
+	"strings"
+)
+
+// Comment is a trailing comment found after a command in an envrc file.
+type Comment struct {
+	Path    string `json:"path,omitempty"` // path of the file, if read by ReadFile
+	Line    int    `json:"line"`           // line number in the file, starting at 1
+	Section string `json:"section"`        // section name, empty for the common lines
+	Code    string `json:"code"`           // the command preceding the comment
+	Text    string `json:"text"`           // comment text without the leading '#'
+}
+
+// Comments returns the trailing comments from r, in order of appearance, as
+// recorded in File.Comments by ParseFile.
+func Comments(r io.Reader) ([]Comment, error) {
+	f, err := ParseFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.Comments, nil
+}
+
+// trailingComment returns the trailing comment of line, if it has one.
+// Whole-line comments are not trailing comments.
+func trailingComment(line string) (code, text string, ok bool) {
+	line = strings.TrimSuffix(line, "\n")
+	i := commentIndex(line)
+	if i < 0 {
+		return "", "", false
+	}
+	code = strings.TrimSpace(line[:i])
+	if code == "" {
+		return "", "", false
+	}
+	return code, strings.TrimSpace(line[i+1:]), true
+}
+
+// commentIndex returns the index of the '#' starting a shell comment in line,
+// or -1 if there is none. Like the shell, a '#' only starts a comment at the
+// beginning of a word and outside of quotes.
+func commentIndex(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return -1
+}
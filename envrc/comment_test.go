@@ -0,0 +1,62 @@
+package envrc
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	file := `# whole-line comments are skipped
+foo=1 # set foo
+enter:
+export foo	# export it
+echo "a # b"
+echo 'a # b' # quoted
+echo a#b
+exit:
+unset foo # clean up
+`
+	want := []Comment{
+		{Line: 2, Section: "", Code: "foo=1", Text: "set foo"},
+		{Line: 4, Section: "enter", Code: "export foo", Text: "export it"},
+		{Line: 6, Section: "enter", Code: "echo 'a # b'", Text: "quoted"},
+		{Line: 9, Section: "exit", Code: "unset foo", Text: "clean up"},
+	}
+
+	got, err := Comments(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comments:\n got %+v\nwant %+v", got, want)
+	}
+
+	enter, _, err := Parse(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(enter, "export foo\t# export it") {
+		t.Errorf("comments were stripped from the enter section:\n%s", enter)
+	}
+}
+
+func TestReadFileComments(t *testing.T) {
+	root := files(t, map[string]string{
+		"common.envrc": "# shared\nexport A=1 # from common\n",
+		"p/.envrc":     "#include ../common.envrc\nexit:\nunset A # drop A\n",
+	})
+	want := []Comment{
+		{Path: filepath.Join(root, "common.envrc"), Line: 2, Code: "export A=1", Text: "from common"},
+		{Path: filepath.Join(root, "p", ".envrc"), Line: 3, Section: "exit", Code: "unset A", Text: "drop A"},
+	}
+
+	f, err := ReadFile(filepath.Join(root, "p", ".envrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Comments, want) {
+		t.Errorf("comments:\n got %+v\nwant %+v", f.Comments, want)
+	}
+}
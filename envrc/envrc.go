@@ -2,7 +2,10 @@
 //
 // Envrc file is a simple text file with shell commands. Different sections
 // are separater with a section header. Lines before any section header are
-// common for every sections.
+// common for every sections. Besides the enter and exit sections, a file may
+// have any number of other sections named by a header line like "reload:".
+// Files read by path may inline other files with a line like
+// "#include ../common.envrc".
 //
 // Given an example envrc file:
 //
@@ -41,130 +44,507 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 )
 
-// Parse returns the parsed enter and exit sections from r.
-func Parse(r io.Reader) (string, string, error) {
+// File is a parsed envrc file.
+type File struct {
+	Common   string            // lines before any section header
+	Enter    string            // lines of the enter section
+	Exit     string            // lines of the exit section
+	Sections map[string]string // lines of other named sections
+
+	// Comments are the trailing comments of the commands, in order of
+	// appearance. They are metadata only; the sections keep them intact.
+	Comments []Comment
+
+	// Path is the path of the file and Source its content with the includes
+	// inlined, as parsed, if read by ReadFile.
+	Path   string
+	Source []byte
+
+	start map[string]int // number of the first parsed line of each section
+	src   []source       // origin of every parsed line, if read by ReadFile
+}
+
+// source is the origin of a line inlined by ReadFile.
+type source struct {
+	path string
+	line int
+}
+
+// position returns the file and line number of the nth parsed line. The path
+// is empty if f was not read by ReadFile.
+func (f *File) position(n int) (string, int) {
+	if n >= 1 && n <= len(f.src) {
+		return f.src[n-1].path, f.src[n-1].line
+	}
+	return "", n
+}
+
+// Section returns the lines of the named section.
+func (f *File) Section(name string) string {
+	switch name {
+	case "enter":
+		return f.Enter
+	case "exit":
+		return f.Exit
+	}
+	return f.Sections[name]
+}
+
+// ParseError is an error in the content of an envrc file.
+type ParseError struct {
+	Path string // path of the file, if known
+	Line int    // line number, starting at 1
+	Msg  string // description of the error
+}
+
+func (e *ParseError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseFile returns the parsed sections from r. The sections are not
+// combined with the common lines and keep their line endings.
+func ParseFile(r io.Reader) (*File, error) { return parse(r, nil) }
+
+// parse is like ParseFile, with src giving the origin of every line for
+// positions in errors.
+func parse(r io.Reader, src []source) (*File, error) {
+	f := &File{start: map[string]int{"": 1}, src: src}
 	scan := bufio.NewScanner(r)
 	scan.Split(scanLines)
 
 	hbuf := new(strings.Builder)
-	ebuf := new(strings.Builder)
-	xbuf := new(strings.Builder)
+	bufs := map[string]*strings.Builder{}
 
-	target := hbuf
-	for scan.Scan() {
+	target, section := hbuf, ""
+	for n := 1; scan.Scan(); n++ {
 		line := scan.Text()
-		switch {
-		case strings.HasPrefix(line, "enter:"):
-			target = ebuf
-			continue
-		case strings.HasPrefix(line, "exit:"):
-			target = xbuf
+		if name, ok := header(line); ok {
+			if bufs[name] != nil {
+				path, line := f.position(n)
+				return nil, &ParseError{path, line, fmt.Sprintf("duplicate %s section", name)}
+			}
+			bufs[name] = new(strings.Builder)
+			target = bufs[name]
+			f.start[name] = n + 1
+			section = name
 			continue
+		}
+		if code, text, ok := trailingComment(line); ok {
+			path, line := f.position(n)
+			f.Comments = append(f.Comments, Comment{path, line, section, code, text})
+		}
+		if _, err := target.WriteString(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	f.Common = hbuf.String()
+	for name, buf := range bufs {
+		switch name {
+		case "enter":
+			f.Enter = buf.String()
+		case "exit":
+			f.Exit = buf.String()
 		default:
-			if _, err := target.WriteString(line); err != nil {
-				return "", "", err
+			if f.Sections == nil {
+				f.Sections = map[string]string{}
 			}
+			f.Sections[name] = buf.String()
 		}
 	}
-	if err := scan.Err(); err != nil {
+	return f, nil
+}
+
+// Parse returns the parsed enter and exit sections from r. Both sections are
+// prefixed with the common lines.
+func Parse(r io.Reader) (string, string, error) {
+	f, err := ParseFile(r)
+	if err != nil {
 		return "", "", err
 	}
+	enter, exit := f.Eval()
+	return enter, exit, nil
+}
 
+// Eval returns the enter and exit sections of f prefixed with the common
+// lines.
+func (f *File) Eval() (string, string) {
 	trim := func(s string) string {
 		s = strings.TrimLeft(s, "\n")
 		s = strings.TrimRight(s, "\n")
 		return s
 	}
 
-	enter := hbuf.String() + ebuf.String()
-	exit := hbuf.String() + xbuf.String()
+	enter := f.Common + f.Enter
+	exit := f.Common + f.Exit
+
+	return trim(enter), trim(exit)
+}
+
+// headerRe matches the header of a named section.
+var headerRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*:$`)
 
-	return trim(enter), trim(exit), nil
+// header reports whether line is a section header and returns the name of
+// the section it starts. The header may be followed by whitespace and a
+// comment, but nothing else, so "enterprise: x" and "enter:x" are not
+// headers. A bare "enterprise:" starts its own section named enterprise
+// rather than the enter section.
+func header(line string) (string, bool) {
+	line = strings.TrimSuffix(line, "\n")
+	if i := commentIndex(line); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimRight(line, " \t")
+	if headerRe.MatchString(line) {
+		return strings.TrimSuffix(line, ":"), true
+	}
+	return "", false
 }
 
 // scanLines is a split function for bufio.Scanner that returns each line of
 // text. It differs from bufio.ScanLines so that this version does not strip
-// end-of-line markers.
+// end-of-line markers. A CRLF marker is returned as a plain newline.
 func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
 	}
 	if i := bytes.IndexByte(data, '\n'); i >= 0 {
 		// We have a full newline-terminated line.
+		if i > 0 && data[i-1] == '\r' {
+			// Replace a CRLF line ending with a plain newline.
+			line := make([]byte, i)
+			copy(line, data[:i-1])
+			line[i-1] = '\n'
+			return i + 1, line, nil
+		}
 		return i + 1, data[0 : i+1], nil
 	}
 	// If we're at EOF, we have a final, non-terminated line. Return it.
 	if atEOF {
-		return len(data), data, nil
+		return len(data), bytes.TrimSuffix(data, []byte{'\r'}), nil
 	}
 	// Request more data.
 	return 0, nil, nil
 }
 
-// Name is the name of the envrc file.
+// Name is the name of the envrc file. It is used when Names is empty.
 var Name = ".envrc"
 
-func eval(path string) (string, string, error) {
-	path = filepath.Join(path, Name)
-	f, err := os.Open(path)
-	if err != nil && os.IsNotExist(err) {
-		return "", "", nil
+// Names are the candidate names of the envrc file. The first one that exists
+// in a directory is used.
+var Names []string
+
+// Find returns the path of the envrc file in dir, or an empty path if there
+// is none.
+func Find(dir string) (string, error) {
+	names := Names
+	if len(names) == 0 {
+		names = []string{Name}
 	}
-	if err != nil {
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// Eval returns the enter and exit sections of the envrc file in dir, both
+// prefixed with the common lines. The sections are empty if dir has no envrc
+// file.
+func Eval(dir string) (string, string, error) {
+	f, err := Lookup(dir)
+	if err != nil || f == nil {
 		return "", "", err
 	}
-	defer f.Close()
-	es, xs, err := Parse(f)
+	es, xs := f.Eval()
+	return es, xs, nil
+}
+
+// Lookup returns the envrc file in dir read by ReadFile, or nil if dir has
+// none.
+func Lookup(dir string) (*File, error) {
+	path, err := Find(dir)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	f, err := ReadFile(path)
 	if err != nil {
-		err = fmt.Errorf("envrc: %s: %v", path, err)
+		return nil, fmt.Errorf("envrc: %w", err)
 	}
-	return es, xs, err
+	return f, nil
 }
 
-const sep = string(os.PathSeparator)
-
 // Chdir changes the environment between a and b directories. The given
 // chdir callback is called for every required path change.
+//
+// The exit sections are evaluated from a up to, but not including, the
+// closest common ancestor of a and b. The enter sections are then evaluated
+// from below the common ancestor down to b. Paths without a common ancestor,
+// such as paths on different volumes, are exited and entered up to their
+// roots.
 func Chdir(a, b string, chdir func(path, data string)) error {
-	a = filepath.Clean(a)
-	b = filepath.Clean(b)
+	return Walk(a, b, func(path, data string, exit bool) { chdir(path, data) })
+}
 
-	r, err := filepath.Rel(a, b)
+// Walk is like Chdir, but also tells the callback whether data is an exit or
+// an enter section.
+func Walk(a, b string, fn func(path, data string, exit bool)) error {
+	return WalkFiles(a, b, func(path string, f *File, exit bool) {
+		es, xs := f.Eval()
+		data := es
+		if exit {
+			data = xs
+		}
+		if data != "" {
+			fn(path, data, exit)
+		}
+	})
+}
+
+// WalkFiles is like Walk, but calls fn with the envrc file of every visited
+// directory that has one, even if the section to evaluate is empty. Unlike
+// separate calls of Find and ReadFile, the sections and the source of the
+// file come from a single read.
+func WalkFiles(a, b string, fn func(path string, f *File, exit bool)) error {
+	a, err := Abs(a)
 	if err != nil {
 		return err
 	}
+	b, err = Abs(b)
+	if err != nil {
+		return err
+	}
+	if EvalSymlinks {
+		a, b = resolve(a), resolve(b)
+	}
 
-	hops := append([]string{a}, strings.Split(r, sep)...)
-	for i := 1; i < len(hops); i++ {
-		hop := hops[i]
+	exits, enters := hops(a, b)
+	if len(Markers) > 0 {
+		exits = within(exits, projectRoot(a))
+		enters = within(enters, projectRoot(b))
+	}
+	for _, path := range exits {
+		if err := visit(path, true, fn); err != nil {
+			return err
+		}
+	}
+	for _, path := range enters {
+		if err := visit(path, false, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvalSymlinks makes Chdir and Walk resolve symbolic links in both paths
+// before comparing them, so a directory reached through a link is the same as
+// its target. Shells usually report the logical path with the links kept,
+// whose parents may not be the physical parents.
+var EvalSymlinks bool
+
+// resolve returns path with its symbolic links evaluated, or path itself if
+// that fails, for example because it does not exist.
+func resolve(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	return path
+}
+
+// Boundary is the directory where Load stops walking up. The envrc file in
+// Boundary itself is still loaded. If Boundary is empty or not an ancestor of
+// the loaded directory, the walk goes up to the root.
+var Boundary string
 
-		// Expand this hop to an absolute path.
-		hops[i] = filepath.Join(hops[i-1], hops[i])
+// Markers are the names of files or directories that mark the root of a
+// project, such as ".git". When set, Chdir and Load ignore the envrc files
+// above the closest project root of a directory. Directories outside of any
+// project are not bounded.
+var Markers []string
 
-		var (
-			path string
-			data string
-			err  error
-		)
+// projectRoot returns the closest ancestor of dir that contains one of the
+// Markers, or an empty path if there is none.
+func projectRoot(dir string) string {
+	for _, path := range ancestors(dir) {
+		for _, marker := range Markers {
+			if _, err := os.Stat(filepath.Join(path, marker)); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
 
-		if hop == ".." {
-			path = hops[i-1]
-			_, data, err = eval(path)
-		} else {
-			path = hops[i]
-			data, _, err = eval(path)
+// within returns the paths that are root or below it. All paths are kept if
+// root is empty.
+func within(paths []string, root string) []string {
+	if root == "" {
+		return paths
+	}
+	var in []string
+	for _, path := range paths {
+		for _, p := range ancestors(path) {
+			if samePath(p, root) {
+				in = append(in, path)
+				break
+			}
 		}
+	}
+	return in
+}
 
+// Load returns the combined enter sections of every envrc file from the root,
+// or Boundary, down to dir. Unlike Chdir, the result does not depend on the
+// previous directory, so it rebuilds the full environment of dir.
+func Load(dir string) (string, error) {
+	dir, err := Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	paths := ancestors(dir)
+	if Boundary != "" {
+		boundary, err := Abs(Boundary)
 		if err != nil {
-			return err
+			return "", err
+		}
+		for i, path := range paths {
+			if samePath(path, boundary) {
+				paths = paths[:i+1]
+				break
+			}
 		}
+	}
 
+	if len(Markers) > 0 {
+		paths = within(paths, projectRoot(dir))
+	}
+
+	var enters []string
+	for i := len(paths) - 1; i >= 0; i-- {
+		data, _, err := Eval(paths[i])
+		if err != nil {
+			return "", err
+		}
 		if data != "" {
-			chdir(path, data)
+			enters = append(enters, data)
 		}
 	}
+	return strings.Join(enters, "\n"), nil
+}
+
+// OnError, if set, is called by Chdir, Walk, and WalkFiles with the error of an envrc
+// file that cannot be read or parsed. If it returns nil, the file is skipped
+// and the walk continues with the remaining paths. Otherwise, or if OnError is
+// not set, the walk stops with the error.
+var OnError func(path string, err error) error
+
+// visit calls fn with the envrc file in path, if any.
+func visit(path string, exit bool, fn func(path string, f *File, exit bool)) error {
+	f, err := Lookup(path)
+	if err != nil {
+		if OnError != nil {
+			return OnError(path, err)
+		}
+		return err
+	}
+	if f != nil {
+		fn(path, f, exit)
+	}
 	return nil
 }
+
+// Abs is like filepath.Abs, but on Windows it first translates MSYS and
+// Cygwin style paths, like the $PWD of Git Bash, with msysPath.
+func Abs(path string) (string, error) {
+	if runtime.GOOS == "windows" {
+		path = msysPath(path)
+	}
+	return filepath.Abs(path)
+}
+
+// msysPath translates an MSYS or Cygwin style path such as /c/work or
+// /cygdrive/c/work to the Windows path C:\work. Other paths are returned as
+// is. A leading /c is always taken for a drive, never for a directory named c
+// in the root of the current drive.
+func msysPath(path string) string {
+	p := path
+	if strings.HasPrefix(p, "/cygdrive/") {
+		p = p[len("/cygdrive"):]
+	}
+	if len(p) < 2 || p[0] != '/' || len(p) > 2 && p[2] != '/' {
+		return path
+	}
+	drive := p[1]
+	if (drive < 'a' || drive > 'z') && (drive < 'A' || drive > 'Z') {
+		return path
+	}
+	rest := strings.ReplaceAll(strings.TrimPrefix(p[2:], "/"), "/", `\`)
+	return strings.ToUpper(string(drive)) + `:\` + rest
+}
+
+// samePath reports whether a and b are the same clean path. Paths are
+// case-insensitive on Windows, and the root of a UNC share may be given with
+// or without a trailing separator.
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(strings.TrimRight(a, `\`), strings.TrimRight(b, `\`))
+	}
+	return a == b
+}
+
+// hops returns the directories to exit and enter when moving from a to b.
+// The exits are ordered from a upwards and the enters downwards to b. Their
+// closest common ancestor, if any, is in neither.
+func hops(a, b string) (exits, enters []string) {
+	pa := ancestors(a)
+	pb := ancestors(b)
+
+	// Find the closest common ancestor as indices into pa and pb.
+	ia, ib := len(pa), len(pb)
+outer:
+	for i := range pa {
+		for j := range pb {
+			if samePath(pa[i], pb[j]) {
+				ia, ib = i, j
+				break outer
+			}
+		}
+	}
+
+	exits = append(exits, pa[:ia]...)
+	for i := ib - 1; i >= 0; i-- {
+		enters = append(enters, pb[i])
+	}
+	return exits, enters
+}
+
+// ancestors returns path followed by each of its parent directories up to and
+// including the root.
+func ancestors(path string) []string {
+	paths := []string{path}
+	for {
+		dir := filepath.Dir(path)
+		if dir == path {
+			return paths
+		}
+		paths = append(paths, dir)
+		path = dir
+	}
+}
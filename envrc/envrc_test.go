@@ -1,6 +1,11 @@
 package envrc
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -18,6 +23,8 @@ func TestParse(t *testing.T) {
 		{"exit:\na", "", "a"},
 		{"enter:\na\nexit:\nb", "a", "b"},
 		{"a\nenter:\na\nexit:\nb", "a\na", "a\nb"},
+		{"enter:\nenter:x\nenter:foo=bar\nexit:\nexit:y", "enter:x\nenter:foo=bar", "exit:y"},
+		{"enter:x\nenter:  \na", "enter:x\na", "enter:x"},
 	}
 
 	for i := range tests {
@@ -37,3 +44,536 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCRLF(t *testing.T) {
+	tests := []string{
+		"a\nenter:\na\nexit:\nb",
+		"a\nenter:\nexport a\n\nreload:\nb\nexit:\nunset a\n",
+		"# comment\nb=1\nenter:\necho $b\nexit:\necho \"bye\"",
+	}
+
+	for i := range tests {
+		lf := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			crlf := strings.ReplaceAll(lf, "\n", "\r\n")
+			want, err := ParseFile(strings.NewReader(lf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseFile(strings.NewReader(crlf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("file:\n got %#v\nwant %#v", got, want)
+			}
+
+			wantEnter, wantExit, _ := Parse(strings.NewReader(lf))
+			enter, exit, err := Parse(strings.NewReader(crlf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enter != wantEnter || exit != wantExit {
+				t.Errorf("sections:\n got %#q, %#q\nwant %#q, %#q", enter, exit, wantEnter, wantExit)
+			}
+		})
+	}
+}
+
+// content returns the sections of f without the positions recorded by the
+// parse, for comparisons.
+func content(f *File) File {
+	return File{Common: f.Common, Enter: f.Enter, Exit: f.Exit, Sections: f.Sections}
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want File
+	}{
+		{"", File{}},
+		{"a", File{Common: "a"}},
+		{"enter:\na", File{Enter: "a"}},
+		{"exit:\na\n", File{Exit: "a\n"}},
+		{"a\nenter:\nb\nexit:\nc", File{Common: "a\n", Enter: "b\n", Exit: "c"}},
+		{"reload:\na\ntest_2:\nb", File{Sections: map[string]string{"reload": "a\n", "test_2": "b"}}},
+		{"enter: \na", File{Enter: "a"}},
+		{"enter:\t# start hooks\na\nexit: # cleanup\nb", File{Enter: "a\n", Exit: "b"}},
+		{"enterprise:\na", File{Sections: map[string]string{"enterprise": "a"}}},
+		{"a\nenterprise: x\n", File{Common: "a\nenterprise: x\n"}},
+		{"a\nenter:\nenterprise=1\n", File{Common: "a\n", Enter: "enterprise=1\n"}},
+		{"a:b\n2x:\nx :\n", File{Common: "a:b\n2x:\nx :\n"}},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			f, err := ParseFile(strings.NewReader(c.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := content(f); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v\nwant %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		file string
+		line int
+	}{
+		{"enter:\na\nenter:\nb", 3},
+		{"a\nexit:\nb\nreload:\nexit:", 5},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := ParseFile(strings.NewReader(c.file))
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("got error %v, want *ParseError", err)
+			}
+			if perr.Line != c.line {
+				t.Errorf("line: got %d, want %d", perr.Line, c.line)
+			}
+		})
+	}
+}
+
+func TestParseErrorWrapped(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a": "enter:\na\nenter:\nb",
+	})
+
+	err := Chdir(root, filepath.Join(root, "a"), func(path, data string) {})
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want *ParseError", err)
+	}
+	if perr.Line != 3 {
+		t.Errorf("line: got %d, want %d", perr.Line, 3)
+	}
+}
+
+func TestFileSection(t *testing.T) {
+	f, err := ParseFile(strings.NewReader("enter:\na\nexit:\nb\nreload:\nc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"enter":   "a\n",
+		"exit":    "b\n",
+		"reload":  "c",
+		"missing": "",
+	} {
+		if got := f.Section(name); got != want {
+			t.Errorf("Section(%q) = %#q, want %#q", name, got, want)
+		}
+	}
+}
+
+func TestHops(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		exits  []string
+		enters []string
+	}{
+		{"/a", "/a", nil, nil},
+		{"/a", "/a/b/c", nil, []string{"/a/b", "/a/b/c"}},
+		{"/a/b/c", "/a", []string{"/a/b/c", "/a/b"}, nil},
+		{"/p/a/x", "/p/b/y", []string{"/p/a/x", "/p/a"}, []string{"/p/b", "/p/b/y"}},
+		{"/a", "/b", []string{"/a"}, []string{"/b"}},
+	}
+	if runtime.GOOS == "windows" {
+		tests = []struct {
+			a, b   string
+			exits  []string
+			enters []string
+		}{
+			{`C:\work`, `D:\repo`, []string{`C:\work`, `C:\`}, []string{`D:\`, `D:\repo`}},
+			{`C:\`, `D:\a\b`, []string{`C:\`}, []string{`D:\`, `D:\a`, `D:\a\b`}},
+			{`c:\a\b`, `C:\a\c`, []string{`c:\a\b`}, []string{`C:\a\c`}},
+			{`\\srv\share\a`, `\\srv\share\b`, []string{`\\srv\share\a`}, []string{`\\srv\share\b`}},
+			{`\\srv\share`, `\\srv\share\a`, nil, []string{`\\srv\share\a`}},
+			{`\\srv\share\a`, `C:\x`, []string{`\\srv\share\a`, `\\srv\share\`}, []string{`C:\`, `C:\x`}},
+			{`\\srv\share\a`, `\\other\share\a`, []string{`\\srv\share\a`, `\\srv\share\`}, []string{`\\other\share\`, `\\other\share\a`}},
+		}
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			exits, enters := hops(c.a, c.b)
+			if !reflect.DeepEqual(exits, c.exits) {
+				t.Errorf("exits:\n got %q\nwant %q", exits, c.exits)
+			}
+			if !reflect.DeepEqual(enters, c.enters) {
+				t.Errorf("enters:\n got %q\nwant %q", enters, c.enters)
+			}
+		})
+	}
+}
+
+// tree creates the given envrc files under a temporary directory and returns
+// its path. Keys are slash-separated directories relative to the root.
+func tree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for dir, data := range files {
+		dir = filepath.Join(root, filepath.FromSlash(dir))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, Name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// chdirs returns the data of every callback made by Chdir(a, b).
+func chdirs(t *testing.T, a, b string) []string {
+	t.Helper()
+	var got []string
+	if err := Chdir(a, b, func(path, data string) {
+		got = append(got, data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestMsysPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/c/work", `C:\work`},
+		{"/c", `C:\`},
+		{"/c/", `C:\`},
+		{"/d/a/b/", `D:\a\b\`},
+		{"/cygdrive/e/repo", `E:\repo`},
+		{"/work", "/work"},
+		{"/1/a", "/1/a"},
+		{"c/work", "c/work"},
+		{`C:\work`, `C:\work`},
+		{"/cygdrive", "/cygdrive"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := msysPath(c.path); got != c.want {
+				t.Errorf("got %#q, want %#q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestChdirUpward(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a":       "enter:\nenter a\nexit:\nexit a",
+		"a/b":     "enter:\nenter b\nexit:\nexit b",
+		"a/b/c":   "enter:\nenter c\nexit:\nexit c",
+		"a/b/c/d": "enter:\nenter d\nexit:\nexit d",
+	})
+
+	a := filepath.Join(root, "a")
+	d := filepath.Join(a, "b", "c", "d")
+
+	got := chdirs(t, d, a)
+	want := []string{"exit d", "exit c", "exit b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("up:\n got %q\nwant %q", got, want)
+	}
+
+	got = chdirs(t, a, d)
+	want = []string{"enter b", "enter c", "enter d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("down:\n got %q\nwant %q", got, want)
+	}
+
+	// An unclean relative jump still visits every intermediate directory.
+	got = chdirs(t, d, filepath.Join(d, "..", "..", ".."))
+	want = []string{"exit d", "exit c", "exit b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unclean:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestChdirSibling(t *testing.T) {
+	root := tree(t, map[string]string{
+		"p":        "enter:\nenter p\nexit:\nexit p",
+		"p/a":      "enter:\nenter a\nexit:\nexit a",
+		"p/a/deep": "enter:\nenter a/deep\nexit:\nexit a/deep",
+		"p/b":      "enter:\nenter b\nexit:\nexit b",
+		"p/b/deep": "enter:\nenter b/deep\nexit:\nexit b/deep",
+	})
+
+	a := filepath.Join(root, "p", "a", "deep")
+	b := filepath.Join(root, "p", "b", "deep")
+
+	got := chdirs(t, a, b)
+	want := []string{"exit a/deep", "exit a", "enter b", "enter b/deep"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sibling:\n got %q\nwant %q", got, want)
+	}
+
+	// Moving out of the shared root crosses its envrc file.
+	got = chdirs(t, a, root)
+	want = []string{"exit a/deep", "exit a", "exit p"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("crossing:\n got %q\nwant %q", got, want)
+	}
+
+	got = chdirs(t, a, a)
+	if len(got) != 0 {
+		t.Errorf("same directory: got %q, want none", got)
+	}
+}
+
+func TestChdirExitOrder(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a":     "enter:\nenter a\nexit:\nexit a",
+		"a/b":   "enter:\nenter b\nexit:\nexit b",
+		"a/b/c": "enter:\nenter c\nexit:\nexit c",
+	})
+
+	a := filepath.Join(root, "a")
+	c := filepath.Join(a, "b", "c")
+
+	enters := chdirs(t, a, c)
+	exits := chdirs(t, c, a)
+	if len(enters) != len(exits) {
+		t.Fatalf("got %d enters and %d exits", len(enters), len(exits))
+	}
+	for i := range enters {
+		// The exit hooks undo the enter hooks in reverse order.
+		want := strings.Replace(enters[len(enters)-1-i], "enter", "exit", 1)
+		if exits[i] != want {
+			t.Errorf("exit %d: got %q, want %q", i, exits[i], want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := tree(t, map[string]string{"a": "", "b": ""})
+	if err := os.WriteFile(filepath.Join(root, "b", ".env"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "c"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(names []string) { Names = names }(Names)
+	Names = []string{".env", Name}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"a", filepath.Join(root, "a", Name)},
+		{"b", filepath.Join(root, "b", ".env")},
+		{"c", ""},
+	}
+	for _, c := range tests {
+		got, err := Find(filepath.Join(root, c.dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("Find(%s): got %q, want %q", c.dir, got, c.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a":     "enter:\nenter a\nexit:\nexit a",
+		"a/b/c": "enter:\nenter c\nexit:\nexit c",
+	})
+	c := filepath.Join(root, "a", "b", "c")
+
+	got, err := Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "enter a\nenter c"; got != want {
+		t.Errorf("got %#q, want %#q", got, want)
+	}
+
+	defer func(b string) { Boundary = b }(Boundary)
+	Boundary = filepath.Join(root, "a", "b")
+
+	got, err = Load(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "enter c"; got != want {
+		t.Errorf("with boundary: got %#q, want %#q", got, want)
+	}
+}
+
+func TestChdirMarkers(t *testing.T) {
+	root := tree(t, map[string]string{
+		"":          "enter:\nenter root\nexit:\nexit root",
+		"proj":      "enter:\nenter proj\nexit:\nexit proj",
+		"proj/sub":  "enter:\nenter sub\nexit:\nexit sub",
+		"other/sub": "enter:\nenter other\nexit:\nexit other",
+	})
+	if err := os.Mkdir(filepath.Join(root, "proj", ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(m []string) { Markers = m }(Markers)
+	Markers = []string{".git"}
+
+	sub := filepath.Join(root, "proj", "sub")
+	parent := filepath.Dir(root)
+
+	got := chdirs(t, sub, parent)
+	want := []string{"exit sub", "exit proj"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("leaving project:\n got %q\nwant %q", got, want)
+	}
+
+	got = chdirs(t, parent, sub)
+	want = []string{"enter proj", "enter sub"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entering project:\n got %q\nwant %q", got, want)
+	}
+
+	// Directories outside of a project are not bounded.
+	got = chdirs(t, parent, filepath.Join(root, "other", "sub"))
+	want = []string{"enter root", "enter other"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outside project:\n got %q\nwant %q", got, want)
+	}
+
+	enter, err := Load(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "enter proj\nenter sub"; enter != want {
+		t.Errorf("load: got %#q, want %#q", enter, want)
+	}
+}
+
+func TestChdirOnError(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a":     "enter:\nenter a\nexit:\nexit a",
+		"a/b":   "enter:\nenter b\nenter:\n",
+		"a/b/c": "enter:\nenter c\nexit:\nexit c",
+	})
+	c := filepath.Join(root, "a", "b", "c")
+
+	if err := Chdir(root, c, func(path, data string) {}); err == nil {
+		t.Fatal("got no error for the duplicate section")
+	}
+
+	defer func(f func(string, error) error) { OnError = f }(OnError)
+	var failed []string
+	OnError = func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}
+
+	got := chdirs(t, root, c)
+	if want := []string{"enter a", "enter c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#q, want %#q", got, want)
+	}
+	if want := []string{filepath.Join(root, "a", "b")}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed: got %q, want %q", failed, want)
+	}
+
+	stop := errors.New("stop")
+	OnError = func(path string, err error) error { return stop }
+	if err := Chdir(root, c, func(path, data string) {}); err != stop {
+		t.Errorf("got %v, want %v", err, stop)
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a":   "enter:\nenter a\n",
+		"a/b": "#include ../../shared\nexit:\nexit b\n",
+	})
+	if err := os.WriteFile(filepath.Join(root, "shared"), []byte("X=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	type call struct {
+		path, source string
+		exit         bool
+	}
+	var got []call
+	if err := WalkFiles(filepath.Join(root, "a", "b"), root, func(path string, f *File, exit bool) {
+		if want := filepath.Join(path, Name); f.Path != want {
+			t.Errorf("%s: got path %s, want %s", path, f.Path, want)
+		}
+		got = append(got, call{path, string(f.Source), exit})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exit section of a is empty, but its file is visited anyway.
+	want := []call{
+		{filepath.Join(root, "a", "b"), "X=1\nexit:\nexit b\n", true},
+		{filepath.Join(root, "a"), "enter:\nenter a\n", true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestChdirSymlinks(t *testing.T) {
+	root := tree(t, map[string]string{
+		"real":     "enter:\nenter real\nexit:\nexit real",
+		"real/sub": "enter:\nenter sub\nexit:\nexit sub",
+	})
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(filepath.Join(root, "real"), link); err != nil {
+		t.Skip(err)
+	}
+	real := filepath.Join(root, "real")
+
+	tests := []struct {
+		a, b    string
+		literal []string
+		want    []string
+	}{
+		{
+			filepath.Join(real, "sub"), filepath.Join(link, "sub"),
+			[]string{"exit sub", "exit real", "enter real", "enter sub"},
+			nil,
+		},
+		{
+			filepath.Join(link, "sub"), real,
+			[]string{"exit sub", "exit real", "enter real"},
+			[]string{"exit sub"},
+		},
+		{
+			root, filepath.Join(link, "sub", "missing"),
+			[]string{"enter real", "enter sub"},
+			[]string{"enter real", "enter sub"},
+		},
+	}
+
+	defer func(b bool) { EvalSymlinks = b }(EvalSymlinks)
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			EvalSymlinks = false
+			if got := chdirs(t, c.a, c.b); !reflect.DeepEqual(got, c.literal) {
+				t.Errorf("literal: got %#q, want %#q", got, c.literal)
+			}
+			EvalSymlinks = true
+			if got := chdirs(t, c.a, c.b); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("resolved: got %#q, want %#q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package envrc
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// varRe matches a $VAR or ${VAR} reference.
+var varRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// assignRe matches a simple KEY=value line, optionally exported.
+var assignRe = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// Expand replaces $VAR and ${VAR} references in the sections of f. Values are
+// taken from the simple KEY=value lines of the common section, in order, so
+// an assignment can refer to the ones before it. A name assigned there is
+// replaced even if its value is empty. Other names are looked up with
+// mapping, which may be nil, and references for which it returns an empty
+// value are left untouched.
+//
+// Expand is intended for tools that consume the sections without a shell. It
+// does not follow shell quoting rules; references inside single quotes are
+// expanded too.
+func (f *File) Expand(mapping func(string) string) {
+	vars := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+		if mapping != nil {
+			v := mapping(name)
+			return v, v != ""
+		}
+		return "", false
+	}
+	expand := func(s string) string {
+		return varRe.ReplaceAllStringFunc(s, func(ref string) string {
+			m := varRe.FindStringSubmatch(ref)
+			if v, ok := lookup(m[1] + m[2]); ok {
+				return v
+			}
+			return ref
+		})
+	}
+
+	for _, a := range Assignments(f.Common) {
+		vars[a.Key] = expand(a.Value)
+	}
+
+	f.Enter = expand(f.Enter)
+	f.Exit = expand(f.Exit)
+	for name, body := range f.Sections {
+		f.Sections[name] = expand(body)
+	}
+}
+
+// Assignment is a simple KEY=value line of an envrc file.
+type Assignment struct {
+	Key   string
+	Value string // value with matching surrounding quotes removed
+}
+
+// Assignments returns the simple assignments in s, in order. Lines may
+// start with export and end with a comment. Other lines are skipped, as are
+// lines with unquoted whitespace in the value, like "A=1 B=2 make", which are
+// commands run with the variables rather than assignments.
+func Assignments(s string) []Assignment {
+	var as []Assignment
+	for _, line := range strings.Split(s, "\n") {
+		if i := commentIndex(line); i >= 0 {
+			line = line[:i]
+		}
+		m := assignRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || strings.ContainsAny(stripQuotes(m[2]), " \t") {
+			continue
+		}
+		as = append(as, Assignment{m[1], unquote(m[2])})
+	}
+	return as
+}
+
+// Environ returns the variables set by the simple assignments in the common
+// lines and the enter section from r, such as for os.Setenv. Later
+// assignments of the same key win. Other lines, including any other shell
+// commands, are skipped, and values are taken literally, so references to
+// other variables are not expanded.
+func Environ(r io.Reader) (map[string]string, error) {
+	enter, _, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{}
+	for _, a := range Assignments(enter) {
+		env[a.Key] = a.Value
+	}
+	return env, nil
+}
+
+// unquote removes matching single or double quotes around s.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
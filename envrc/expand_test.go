@@ -0,0 +1,91 @@
+package envrc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFileExpand(t *testing.T) {
+	file := `PROJECT=foo
+export ROOT="/src/$PROJECT"
+EMPTY=
+enter:
+cd ${ROOT}/bin; echo $PROJECT $HOME $UNSET x${EMPTY}x
+exit:
+echo ${PROJECT}s
+reload:
+echo $ROOT
+`
+	f, err := ParseFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Expand(func(name string) string {
+		if name == "HOME" {
+			return "/home/me"
+		}
+		return ""
+	})
+
+	if want := "cd /src/foo/bin; echo foo /home/me $UNSET xx\n"; f.Enter != want {
+		t.Errorf("enter:\n got %#q\nwant %#q", f.Enter, want)
+	}
+	if want := "echo foos\n"; f.Exit != want {
+		t.Errorf("exit:\n got %#q\nwant %#q", f.Exit, want)
+	}
+	if want := "echo /src/foo\n"; f.Section("reload") != want {
+		t.Errorf("reload:\n got %#q\nwant %#q", f.Section("reload"), want)
+	}
+	if !strings.HasPrefix(f.Common, "PROJECT=foo\n") {
+		t.Errorf("common section was modified:\n%s", f.Common)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []Assignment
+	}{
+		{"A=1\nexport B='two words'\necho C=3\n  D=\"\"\n", []Assignment{{"A", "1"}, {"B", "two words"}, {"D", ""}}},
+		{"FOO=bar # note\nexport BAZ=\"q\" # c\nE='#'\nF=a#b\n", []Assignment{{"FOO", "bar"}, {"BAZ", "q"}, {"E", "#"}, {"F", "a#b"}}},
+		{"X=1 Y=2 make\nG=a b\nH=\"a b\" c\nI=\"a b\"\t\n", []Assignment{{"I", "a b"}}},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := Assignments(c.s); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %q\nwant %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	file := `A=1
+B='single $A'
+enter:
+export C="double" # comment
+echo D=4
+G=7 make
+if true; then E=5; fi
+A=override
+exit:
+F=6
+`
+	want := map[string]string{"A": "override", "B": "single $A", "C": "double"}
+
+	got, err := Environ(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("environ:\n got %v\nwant %v", got, want)
+	}
+
+	if _, err := Environ(strings.NewReader("enter:\nenter:\n")); err == nil {
+		t.Error("got no error for a duplicate section")
+	}
+}
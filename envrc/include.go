@@ -0,0 +1,128 @@
+package envrc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth is the maximum nesting depth of include directives.
+const maxIncludeDepth = 8
+
+// ReadFile returns the parsed sections of the envrc file at path, like
+// ParseFile, after resolving its include directives. A line of the form
+// "#include <path>" is replaced with the content of the named file; relative
+// paths are resolved against the directory of the including file. Being a
+// comment to the shell, the directive cannot clash with the commands of a
+// file, such as a shell function named include. The content
+// is inlined as is, so section headers in an included file switch sections
+// just like in the including file. Includes may nest up to 8 levels deep, and
+// a file including itself, directly or through others, is an error, as is
+// including anything but a regular file.
+//
+// Parse errors refer to the file and line an offending line comes from.
+func ReadFile(path string) (*File, error) {
+	data, src, err := inline(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(bytes.NewReader(data), src)
+	if err != nil {
+		return nil, err
+	}
+	f.Path, f.Source = path, data
+	return f, nil
+}
+
+// Source returns the content of the envrc file at path with its include
+// directives resolved, as parsed by ReadFile. Without directives, it is the
+// content of the file itself.
+func Source(path string) ([]byte, error) {
+	data, _, err := inline(path)
+	return data, err
+}
+
+// inline returns the content of the file at path with its include directives
+// resolved, and the origin of every line.
+func inline(path string) ([]byte, []source, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		buf bytes.Buffer
+		src []source
+	)
+	if err := include(&buf, &src, path, nil); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), src, nil
+}
+
+// include writes the content of the file at path to buf, recursively
+// replacing include directives, and appends the origin of every written line
+// to src. The stack holds the including files.
+func include(buf *bytes.Buffer, src *[]source, path string, stack []string) error {
+	for _, p := range stack {
+		if samePath(p, path) {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack, path), " -> "))
+		}
+	}
+	if len(stack) > maxIncludeDepth {
+		return fmt.Errorf("includes nested more than %d levels deep", maxIncludeDepth)
+	}
+
+	// Only regular files are read: opening a FIFO or a device such as /dev/tty
+	// could block forever, and includes are resolved before a file is known
+	// to be trusted.
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	scan.Split(scanLines)
+	for n := 1; scan.Scan(); n++ {
+		line := scan.Text()
+		name, ok := includeDirective(line)
+		if !ok {
+			buf.WriteString(line)
+			*src = append(*src, source{path, n})
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		if err := include(buf, src, name, append(stack, path)); err != nil {
+			return fmt.Errorf("%s:%d: include %s: %w", path, n, name, err)
+		}
+		if b := buf.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// includeDirective reports whether line is an include directive and returns
+// the path it names.
+func includeDirective(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != "#include" {
+		return "", false
+	}
+	return fields[1], true
+}
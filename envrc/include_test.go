@@ -0,0 +1,184 @@
+package envrc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// files writes the files to a temporary directory and returns its path.
+func files(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadFile(t *testing.T) {
+	root := files(t, map[string]string{
+		"common.envrc":   "enter:\nexport A=1\nexit:\nunset A",
+		"p/.envrc":       "B=2\n#include ../common.envrc\nexport B\n",
+		"p/q/.envrc":     "#include sub/vars\nenter:\necho q\n",
+		"p/q/sub/vars":   "#include ../more\n",
+		"p/q/more":       "C=3\n",
+		"p/plain/.envrc": "echo include a b\n",
+		"p/func/.envrc":  "include() { . \"$1\"; }\ninclude lib.sh\n# include lib.sh\n",
+	})
+
+	tests := []struct {
+		path string
+		want *File
+	}{
+		{"p/.envrc", &File{Common: "B=2\n", Enter: "export A=1\n", Exit: "unset A\nexport B\n"}},
+		{"p/q/.envrc", &File{Common: "C=3\n", Enter: "echo q\n"}},
+		{"p/plain/.envrc", &File{Common: "echo include a b\n"}},
+		{"p/func/.envrc", &File{Common: "include() { . \"$1\"; }\ninclude lib.sh\n# include lib.sh\n"}},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := ReadFile(filepath.Join(root, filepath.FromSlash(c.path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := content(got); !reflect.DeepEqual(got, *c.want) {
+				t.Errorf("file:\n got %#v\nwant %#v", got, *c.want)
+			}
+		})
+	}
+}
+
+func TestReadFileError(t *testing.T) {
+	root := files(t, map[string]string{
+		"common": "A=1\nenter:\nexport A\n",
+		".envrc": "#include common\nB=2\nenter:\necho b\n",
+		"short":  "x\n#include common\nexit:\nexit:\n",
+		"top":    "a\nb\n#include bad\n",
+		"bad":    "exit:\nexit:\n",
+	})
+
+	tests := []struct {
+		name string
+		path string
+		line int
+	}{
+		{".envrc", ".envrc", 3},
+		{"top", "bad", 2},
+		{"short", "short", 4},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := ReadFile(filepath.Join(root, c.name))
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("got error %v, want *ParseError", err)
+			}
+			if want := filepath.Join(root, c.path); perr.Path != want || perr.Line != c.line {
+				t.Errorf("got %s:%d, want %s:%d", perr.Path, perr.Line, want, c.line)
+			}
+		})
+	}
+
+	if runtime.GOOS != "windows" {
+		dev := filepath.Join(t.TempDir(), ".envrc")
+		if err := os.WriteFile(dev, []byte("#include /dev/null\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadFile(dev); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+			t.Errorf("device: got error %v, want not a regular file", err)
+		}
+		if _, err := Source(dev); err == nil {
+			t.Error("device: Source got no error")
+		}
+	}
+}
+
+func TestReadFileCycle(t *testing.T) {
+	root := files(t, map[string]string{
+		"self": "#include self\n",
+		"a":    "#include b\n",
+		"b":    "#include ./a\n",
+	})
+
+	for _, name := range []string{"self", "a"} {
+		_, err := ReadFile(filepath.Join(root, name))
+		if err == nil || !strings.Contains(err.Error(), "include cycle") {
+			t.Errorf("%s: got %v, want an include cycle error", name, err)
+		}
+	}
+}
+
+func TestReadFileDepth(t *testing.T) {
+	m := map[string]string{}
+	for i := 0; i <= maxIncludeDepth+1; i++ {
+		m[strconv.Itoa(i)] = "#include " + strconv.Itoa(i+1) + "\n"
+	}
+	m[strconv.Itoa(maxIncludeDepth+2)] = "A=1\n"
+	root := files(t, m)
+
+	_, err := ReadFile(filepath.Join(root, "0"))
+	if err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("got %v, want a nesting error", err)
+	}
+
+	f, err := ReadFile(filepath.Join(root, "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Common != "A=1\n" {
+		t.Errorf("got %#q, want %#q", f.Common, "A=1\n")
+	}
+}
+
+func TestChdirInclude(t *testing.T) {
+	root := tree(t, map[string]string{
+		"a": "#include ../shared\nenter:\nenter a\n",
+	})
+	if err := os.WriteFile(filepath.Join(root, "shared"), []byte("X=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := chdirs(t, root, filepath.Join(root, "a"))
+	if want := []string{"X=1\nenter a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#q, want %#q", got, want)
+	}
+}
+
+func TestSource(t *testing.T) {
+	root := files(t, map[string]string{
+		"plain": "A=1\nenter:\necho a",
+		"top":   "#include plain\nB=2\n",
+	})
+
+	got, err := Source(filepath.Join(root, "plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A=1\nenter:\necho a"; string(got) != want {
+		t.Errorf("plain: got %#q, want %#q", got, want)
+	}
+
+	got, err = Source(filepath.Join(root, "top"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A=1\nenter:\necho a\nB=2\n"; string(got) != want {
+		t.Errorf("include: got %#q, want %#q", got, want)
+	}
+}
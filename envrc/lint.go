@@ -0,0 +1,165 @@
+package envrc
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Warning is a potentially dangerous command found by Lint.
+type Warning struct {
+	Path    string // path of the file, if read by ReadFile
+	Line    int    // line number in the file, starting at 1
+	Section string // section name, empty for the common lines
+	Text    string // the offending line
+	Msg     string // description of the danger
+}
+
+// lintRules are the heuristics used by Lint. They match a line without its
+// quoted strings and comment.
+var lintRules = []struct {
+	match func(string) bool
+	msg   string
+}{
+	{forcedRemoval, "recursive forced removal"},
+	{regexp.MustCompile(`\b(curl|wget)\b.*\|\s*(sudo\s+)?(ba|da|k|z|fi)?sh\b`).MatchString, "downloaded script piped to a shell"},
+}
+
+// Lint returns warnings about dangerous looking commands in the sections of
+// f, such as recursive removals or downloads piped to a shell. The checks are
+// heuristic and meant to be shown before an envrc file is trusted, not to
+// reject it. Unquoted command substitutions are only reported in the lines run
+// on enter. Comments are ignored. For a File read by ReadFile, the warnings
+// locate lines from included files in those files. The warnings are sorted by
+// path and line.
+func Lint(f *File) []Warning {
+	var warnings []Warning
+	lint := func(section, s string, enter bool) {
+		warn := func(i int, line, msg string) {
+			path, n := "", i+1 // relative to the section of a File not parsed
+			if start := f.start[section]; start > 0 {
+				path, n = f.position(start + i)
+			}
+			warnings = append(warnings, Warning{path, n, section, strings.TrimSpace(line), msg})
+		}
+		for i, line := range strings.Split(s, "\n") {
+			code := line
+			if j := commentIndex(code); j >= 0 {
+				code = code[:j]
+			}
+			bare := stripQuotes(code)
+			for _, rule := range lintRules {
+				if rule.match(bare) {
+					warn(i, line, rule.msg)
+				}
+			}
+			if enter && unquotedSubst(code) {
+				warn(i, line, "unquoted command substitution")
+			}
+		}
+	}
+
+	lint("", f.Common, true)
+	lint("enter", f.Enter, true)
+	lint("exit", f.Exit, false)
+
+	names := make([]string, 0, len(f.Sections))
+	for name := range f.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lint(name, f.Sections[name], false)
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i], warnings[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+	return warnings
+}
+
+// forcedRemoval reports whether code runs rm with both the recursive and the
+// force flags, given together or apart.
+func forcedRemoval(code string) bool {
+	fields := strings.Fields(code)
+	for i, field := range fields {
+		if field != "rm" {
+			continue
+		}
+		var recursive, force bool
+	args:
+		for _, arg := range fields[i+1:] {
+			switch {
+			case strings.ContainsAny(arg, ";&|"):
+				break args // end of the command
+			case arg == "--recursive":
+				recursive = true
+			case arg == "--force":
+				force = true
+			case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+				recursive = recursive || strings.ContainsAny(arg, "rR")
+				force = force || strings.Contains(arg, "f")
+			}
+		}
+		if recursive && force {
+			return true
+		}
+	}
+	return false
+}
+
+// stripQuotes returns line without the contents of its quoted strings.
+func stripQuotes(line string) string {
+	var (
+		b     strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				b.WriteByte(c)
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+			continue
+		case c == '\\':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// unquotedSubst reports whether line has a command substitution outside of
+// quotes, where its output is subject to word splitting and globbing.
+func unquotedSubst(line string) bool {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '`', c == '$' && i+1 < len(line) && line[i+1] == '(' && !strings.HasPrefix(line[i:], "$(("):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package envrc
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	file := "# rm -rf / in a comment is fine\n" +
+		"PATH=`pwd`/bin:$PATH\n" +
+		"enter:\n" +
+		"rm -rf build\n" +
+		"curl -fsSL https://example.com/install | sh\n" +
+		"export FOO=\"$(cat foo)\"\n" +
+		"reload:\n" +
+		"wget -qO- https://example.com/x | sudo bash\n" +
+		"exit:\n" +
+		"rm -r -f tmp # clean up\n" +
+		"unset BAR=$(bar)\n"
+	want := []Warning{
+		{Line: 2, Section: "", Text: "PATH=`pwd`/bin:$PATH", Msg: "unquoted command substitution"},
+		{Line: 4, Section: "enter", Text: "rm -rf build", Msg: "recursive forced removal"},
+		{Line: 5, Section: "enter", Text: "curl -fsSL https://example.com/install | sh", Msg: "downloaded script piped to a shell"},
+		{Line: 8, Section: "reload", Text: "wget -qO- https://example.com/x | sudo bash", Msg: "downloaded script piped to a shell"},
+		{Line: 10, Section: "exit", Text: "rm -r -f tmp # clean up", Msg: "recursive forced removal"},
+	}
+
+	f, err := ParseFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Lint(f)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLintInclude(t *testing.T) {
+	root := files(t, map[string]string{
+		"common": "A=1\nrm -rf /tmp/a\n",
+		".envrc": "enter:\necho a\n#include common\nrm -fr b\n",
+	})
+
+	f, err := ReadFile(filepath.Join(root, ".envrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Warning{
+		{filepath.Join(root, ".envrc"), 4, "enter", "rm -fr b", "recursive forced removal"},
+		{filepath.Join(root, "common"), 2, "enter", "rm -rf /tmp/a", "recursive forced removal"},
+	}
+	if got := Lint(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLintUnparsed(t *testing.T) {
+	// Without a parse, the lines are counted within each section.
+	f := &File{Exit: "echo a\nrm -rf b\n"}
+	want := []Warning{{Line: 2, Section: "exit", Text: "rm -rf b", Msg: "recursive forced removal"}}
+	if got := Lint(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	tests := []string{
+		"rm -f foo",
+		"rm -r foo",
+		"rm -r foo && ls -f",
+		"echo 'rm -rf /'",
+		"curl -o install.sh https://example.com/install",
+		"echo '$(not run)'",
+		"FOO=\"$(pwd)\"",
+		"N=$((1 + 2))",
+		"echo \\$(foo)",
+	}
+
+	for i := range tests {
+		s := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := Lint(&File{Enter: s}); got != nil {
+				t.Errorf("%#q: got %+v", s, got)
+			}
+		})
+	}
+}
@@ -1 +1,3 @@
 module github.com/pxi/x
+
+go 1.24
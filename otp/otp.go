@@ -0,0 +1,165 @@
+// Package otp implements the HOTP (RFC 4226) and TOTP (RFC 6238) one-time
+// password algorithms.
+//
+// Secrets are base32 encoded, as in the otpauth URIs of most providers. By
+// default codes have 6 digits, TOTP periods are 30 seconds, and the HMAC uses
+// SHA-1. Options change these parameters.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// config holds the parameters set by options.
+type config struct {
+	digits    int
+	period    time.Duration
+	algorithm string
+}
+
+// Option is an optional parameter of the code computation.
+type Option func(*config)
+
+// Digits sets the number of digits in a code, from 6 to 8.
+func Digits(n int) Option { return func(c *config) { c.digits = n } }
+
+// Period sets the length of a TOTP period, in whole seconds.
+func Period(d time.Duration) Option { return func(c *config) { c.period = d } }
+
+// Algorithm sets the HMAC hash by name: sha1, sha256, or sha512.
+func Algorithm(name string) Option {
+	return func(c *config) { c.algorithm = strings.ToLower(name) }
+}
+
+// hashes are the supported HMAC hash functions by name.
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func newConfig(opts []Option) (*config, error) {
+	c := &config{digits: 6, period: 30 * time.Second, algorithm: "sha1"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.digits < 6 || c.digits > 8 {
+		return nil, fmt.Errorf("otp: invalid number of digits: %d", c.digits)
+	}
+	if c.period < time.Second || c.period%time.Second != 0 {
+		return nil, fmt.Errorf("otp: invalid period: %v", c.period)
+	}
+	if _, ok := hashes[c.algorithm]; !ok {
+		return nil, fmt.Errorf("otp: unknown algorithm: %s", c.algorithm)
+	}
+	return c, nil
+}
+
+// seconds returns the period in seconds.
+func (c *config) seconds() int64 { return int64(c.period / time.Second) }
+
+// Counter returns the TOTP counter value for the period of t.
+func Counter(t time.Time, opts ...Option) (int64, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix() / c.seconds(), nil
+}
+
+// Remaining returns the time left at t in its TOTP period.
+func Remaining(t time.Time, opts ...Option) (time.Duration, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return 0, err
+	}
+	s := c.seconds()
+	return time.Duration(s-t.Unix()%s) * time.Second, nil
+}
+
+// TOTP returns the code for the period of t using the secret.
+func TOTP(secret string, t time.Time, opts ...Option) (string, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return "", err
+	}
+	return c.code(secret, t.Unix()/c.seconds())
+}
+
+// HOTP returns the code for the counter value using the secret.
+func HOTP(secret string, counter int64, opts ...Option) (string, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return "", err
+	}
+	return c.code(secret, counter)
+}
+
+// Verify reports whether code is the TOTP code for the period of t, or for
+// any period within window periods of it. The codes are compared in constant
+// time, and every period in the window is checked.
+func Verify(secret, code string, t time.Time, window int, opts ...Option) (bool, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return false, err
+	}
+	counter := t.Unix() / c.seconds()
+	ok := 0
+	for i := -window; i <= window; i++ {
+		want, err := c.code(secret, counter+int64(i))
+		if err != nil {
+			return false, err
+		}
+		ok |= subtle.ConstantTimeCompare([]byte(want), []byte(code))
+	}
+	return ok == 1, nil
+}
+
+// code computes the code for a counter value using the secret.
+func (c *config) code(secret string, counter int64) (string, error) {
+	k, err := DecodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	hash := hmac.New(hashes[c.algorithm], k)
+	if err := binary.Write(hash, binary.BigEndian, counter); err != nil {
+		return "", err
+	}
+
+	p := hash.Sum(nil)
+	i := p[len(p)-1] & 0x0f
+	n := binary.BigEndian.Uint32(p[i : i+4])
+	n &= 0x7fffffff
+
+	mod := uint32(1)
+	for d := 0; d < c.digits; d++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", c.digits, n%mod), nil
+}
+
+// DecodeSecret returns the key of a base32 encoded secret. Spaces, as added by
+// some providers for readability, are ignored, letters may be lowercase, and
+// the padding may be missing.
+func DecodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	if n := len(s) % 8; n != 0 {
+		s += strings.Repeat("=", 8-n)
+	}
+	k, err := base32.StdEncoding.DecodeString(s)
+	if err != nil || len(k) == 0 {
+		return nil, fmt.Errorf("otp: malformed secret: not a base32 encoded key")
+	}
+	return k, nil
+}
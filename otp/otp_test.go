@@ -0,0 +1,229 @@
+package otp
+
+import (
+	"encoding/base32"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The seeds of the RFC 6238 appendix B, base32 encoded.
+var (
+	seed1   = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	seed256 = base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+	seed512 = base32.StdEncoding.EncodeToString([]byte("1234567890123456789012345678901234567890123456789012345678901234"))
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D.
+	tests := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for i := range tests {
+		want := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := HOTP(seed1, int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B.
+	tests := []struct {
+		unix   int64
+		sha1   string
+		sha256 string
+		sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for _, v := range []struct{ secret, algorithm, want string }{
+				{seed1, "sha1", c.sha1},
+				{seed256, "sha256", c.sha256},
+				{seed512, "sha512", c.sha512},
+			} {
+				got, err := TOTP(v.secret, time.Unix(c.unix, 0), Digits(8), Algorithm(v.algorithm))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != v.want {
+					t.Errorf("%s: got %q, want %q", v.algorithm, got, v.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTOTPDefaults(t *testing.T) {
+	// The 6 digit default keeps the low digits of the 8 digit code.
+	got, err := TOTP(seed1, time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "287082"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		unix   int64
+		window int
+		ok     bool
+	}{
+		{1111111111, 0, true},
+		{1111111111 - 30, 0, false},
+		{1111111111 - 30, 1, true},
+		{1111111111 + 30, 1, true},
+		{1111111111 + 60, 1, false},
+		{1111111111 + 60, 2, true},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			code, err := TOTP(seed1, time.Unix(c.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := Verify(seed1, code, now, c.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != c.ok {
+				t.Errorf("got %v, want %v", ok, c.ok)
+			}
+		})
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want time.Duration
+	}{
+		{0, 30 * time.Second},
+		{59, time.Second},
+		{1111111111, 29 * time.Second},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := Remaining(time.Unix(c.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	tests := [][]Option{
+		{Digits(5)},
+		{Digits(9)},
+		{Period(0)},
+		{Period(1500 * time.Millisecond)},
+		{Algorithm("md5")},
+	}
+
+	for i := range tests {
+		opts := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, err := TOTP(seed1, time.Unix(0, 0), opts...); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	want := "Hello!\xde\xad\xbe\xef"
+	tests := []string{
+		"JBSWY3DPEHPK3PXP",
+		"jbswy3dpehpk3pxp",
+		"JBSW Y3DP EHPK 3PXP",
+		" jbsw y3dp\tehpk 3pxp ",
+	}
+
+	for i := range tests {
+		s := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := DecodeSecret(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeSecretPadding(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"MZXW6===", "foo"},
+		{"MZXW6", "foo"},
+		{"mzxw 6", "foo"},
+		{"MZXW6YQ", "foob"},
+		{"MZXW6YTB", "fooba"},
+		{"MZXW6YTBOI", "foobar"},
+		{"MZXW6YTBOI======", "foobar"},
+	}
+
+	for i := range tests {
+		c := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := DecodeSecret(c.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSecretInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"not-base32!",
+		"MZX",
+		"JBSWY3DPEHPK3PX1",
+	}
+
+	for i := range tests {
+		s := tests[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, err := DecodeSecret(s); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}